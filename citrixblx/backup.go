@@ -0,0 +1,115 @@
+package citrixblx
+
+import (
+	"fmt"
+	"log"
+)
+
+// back up blx.conf, licenses and, when the package is about to change,
+// the extracted install packages of the running version
+func backupBLX(b *blx, pkg bool) error {
+	backupDir := b.filePath["backupDir"]
+	_, err := execSudoCmdHost(b, fmt.Sprintf("rm -rf %s ; mkdir -p %s/license", backupDir, backupDir))
+	if err != nil {
+		return fmt.Errorf("Unable to create backup directory %s.\r\n%v", backupDir, err)
+	}
+	_, err = execSudoCmdHost(b, fmt.Sprintf("if [ -f %s ] ; then cp -a %s %s/blx.conf ; fi", blxConfigFile, blxConfigFile, backupDir))
+	if err != nil {
+		return fmt.Errorf("Unable to back up %s.\r\n%v", blxConfigFile, err)
+	}
+	_, err = execSudoCmdHost(b, fmt.Sprintf("if [ -d %s ] ; then cp -a %s/. %s/license/ ; fi", blxLicensePath, blxLicensePath, backupDir))
+	if err != nil {
+		return fmt.Errorf("Unable to back up licenses.\r\n%v", err)
+	}
+
+	b.backupPkg = false
+	if pkg {
+		_, err = execSudoCmdHost(b, fmt.Sprintf("test -n \\\"\\$(ls -A %s 2>/dev/null)\\\"", b.filePath["blxInstallPath"]))
+		if err != nil {
+			log.Printf("[WARN]  citrixblx-provider: No install packages of the running BLX to back up, package cannot be rolled back")
+		} else {
+			_, err = execSudoCmdHost(b, fmt.Sprintf("cp -a %s %s/blx_install", b.filePath["blxInstallPath"], backupDir))
+			if err != nil {
+				return fmt.Errorf("Unable to back up BLX install packages.\r\n%v", err)
+			}
+			b.backupPkg = true
+		}
+	}
+
+	b.backup = true
+	log.Printf("[INFO]  citrixblx-provider: Backup of BLX %s config in %s SUCCESS", b.id, backupDir)
+	return nil
+}
+
+// stop BLX and back it up, BLX is started again when the backup fails
+// so that nothing is left half-applied
+func stopAndBackupBLX(b *blx, pkg bool) error {
+	err := stopBLX(b)
+	if err != nil {
+		return err
+	}
+	err = backupBLX(b, pkg)
+	if err != nil {
+		errStart := startBLX(b)
+		if errStart != nil {
+			return fmt.Errorf("Backup of BLX %s failed and BLX could not be started again, BLX may be down.\r\nBackup error - %v\r\nStart error - %v", b.id, err, errStart)
+		}
+		return fmt.Errorf("Backup of BLX %s failed, nothing changed and BLX started again.\r\n%v", b.id, err)
+	}
+	return nil
+}
+
+// put back the backed up package, blx.conf and licenses, and start BLX
+func restoreBLX(b *blx) error {
+	if !b.backup {
+		return fmt.Errorf("No backup of BLX %s to restore", b.id)
+	}
+	backupDir := b.filePath["backupDir"]
+
+	err := stopBLX(b)
+	if err != nil {
+		return err
+	}
+
+	if b.backupPkg {
+		err = installBLXPackages(b, fmt.Sprintf("%s/blx_install", backupDir))
+		if err != nil {
+			return err
+		}
+		execSudoCmdHost(b, fmt.Sprintf("rm -rf %s ; cp -a %s/blx_install %s", b.filePath["blxInstallPath"], backupDir, b.filePath["blxInstallPath"]))
+	}
+
+	_, err = execSudoCmdHost(b, fmt.Sprintf("if [ -f %s/blx.conf ] ; then cp -a %s/blx.conf %s ; fi", backupDir, backupDir, blxConfigFile))
+	if err != nil {
+		return fmt.Errorf("Unable to restore %s.\r\n%v", blxConfigFile, err)
+	}
+	_, err = execSudoCmdHost(b, fmt.Sprintf("mkdir -p %s ; rm -f %s/* ; cp -a %s/license/. %s/", blxLicensePath, blxLicensePath, backupDir, blxLicensePath))
+	if err != nil {
+		return fmt.Errorf("Unable to restore licenses.\r\n%v", err)
+	}
+
+	err = createStopScript(b)
+	if err != nil {
+		return err
+	}
+	err = createStartScript(b)
+	if err != nil {
+		return err
+	}
+	err = startBLX(b)
+	if err != nil {
+		return err
+	}
+	log.Printf("[INFO]  citrixblx-provider: BLX %s restored from backup", b.id)
+	return nil
+}
+
+// restore the backup after a failed update, the error covers both
+func rollbackBLX(b *blx, updateErr error) error {
+	log.Printf("[ERROR]  citrixblx-provider: Update of BLX %s failed, rolling back. %v", b.id, updateErr)
+	err := restoreBLX(b)
+	if err != nil {
+		return fmt.Errorf("Update of BLX %s failed and rollback failed, BLX may be down.\r\nUpdate error - %v\r\nRollback error - %v", b.id, updateErr, err)
+	}
+	return fmt.Errorf("Update of BLX %s failed, previous blx.conf, licenses and package restored and BLX restarted.\r\nUpdate error - %v", b.id, updateErr)
+}
@@ -2,10 +2,8 @@ package citrixblx
 
 import (
 	"fmt"
-	"golang.org/x/crypto/ssh"
 	"log"
 	"net"
-	"path/filepath"
 	"strconv"
 	"strings"
 	"time"
@@ -21,19 +19,45 @@ const (
 )
 
 type blx struct {
-	id             string
-	source         string
-	host           map[string]string
-	config         map[string]string
-	mlx            map[string]string
-	hostSession    *ssh.Client
-	nsSession      *ssh.Client
-	cliCmd         []string
-	licenseList    []string
-	dist           string
-	password       string
-	managementMode bool
-	filePath       map[string]string
+	id               string
+	source           string
+	host             map[string]string
+	config           map[string]string
+	mlx              map[string]string
+	hostSession      Transport
+	nsSession        Transport
+	cliCmd           []string
+	licenseList      []string
+	licenseCheck     string
+	licenseFeatures  []licenseFeature
+	pooledLicense    *pooledLicense
+	licenseStatus    map[string]string
+	upgradeMode      string
+	drain            *drainConfig
+	drained          []drainedVserver
+	backup           bool
+	backupPkg        bool
+	lockID           string
+	depBundle        string
+	enableEPEL       bool
+	preflight        map[string]string
+	workerCPUMask    string
+	rebootRequired   bool
+	rebootIfRequired bool
+	sriov            []sriovPF
+	sriovRemoved     []string
+	sriovInterfaces  []string
+	mlxFirmware      []mlxFirmware
+	mlxFwVersion     map[string]string
+	dist             string
+	distro           string
+	distroVersion    string
+	pkg              pkgManager
+	pkgErr           error
+	pkgLockTimeout   time.Duration
+	password         string
+	managementMode   bool
+	filePath         map[string]string
 }
 
 func getHostInfo(d map[string]interface{}) map[string]string {
@@ -44,6 +68,7 @@ func getHostInfo(d map[string]interface{}) map[string]string {
 		"keyfile",
 		"port",
 		"ssh_hostkey_check",
+		"connection",
 	}
 	var host = make(map[string]string)
 	for _, key := range hostKeyList {
@@ -71,6 +96,9 @@ func getConfigInfo(d map[string]interface{}) map[string]string {
 		"total_hugepage_mem",
 		"blx_managed_host",
 		"host_ipaddress",
+		"worker_cpus",
+		"worker_cpus_skip_core0",
+		"worker_cpus_skip_siblings",
 	}
 	var config = make(map[string]string)
 	for _, key := range configKeyList {
@@ -100,6 +128,30 @@ func validateBLX(b blx) error {
 		return fmt.Errorf("Password field must be set for BLX")
 	}
 
+	if b.config["worker_cpus"] != "" && b.config["worker_processes"] != "" {
+		return fmt.Errorf("Only one of worker_cpus and worker_processes can be set for BLX")
+	}
+
+	err := validateSRIOV(b.sriov)
+	if err != nil {
+		return err
+	}
+
+	err = validateLicenseCheck(b.licenseCheck)
+	if err != nil {
+		return err
+	}
+
+	err = validatePooledLicense(b.pooledLicense)
+	if err != nil {
+		return err
+	}
+
+	err = validateUpgradeMode(b.upgradeMode)
+	if err != nil {
+		return err
+	}
+
 	return nil
 }
 
@@ -108,6 +160,11 @@ func initBLXVar(b *blx) {
 
 	b.filePath["blxInstallPath"] = fmt.Sprintf("%s/blx_install", b.filePath["terraformInstallDir"])
 
+	b.filePath["depBundlePath"] = fmt.Sprintf("%s/dependency_bundle", b.filePath["terraformInstallDir"])
+
+	b.filePath["backupDir"] = fmt.Sprintf("%s/backup", b.filePath["terraformInstallDir"])
+	b.filePath["journal"] = fmt.Sprintf("%s/journal", b.filePath["terraformInstallDir"])
+
 	b.filePath["mlxDir"] = fmt.Sprintf("%s/mellanox", b.filePath["terraformInstallDir"])
 
 	b.filePath["blxStartScript"] = fmt.Sprintf("%s/blx_start.sh", b.filePath["terraformInstallDir"])
@@ -123,7 +180,9 @@ func printDebugHostInfo(b *blx) {
 	execSudoCmdHost(b, "ip route show")
 }
 
-func initBLXHost(b *blx) error {
+// terraform install dir for the sudo command, script and upload files,
+// enough to run commands on the host
+func initHostDir(b *blx) error {
 	b.filePath = make(map[string]string)
 	b.filePath["terraformInstallDir"] = "~/.terraform_blx"
 	_, err := execCmdHost(b, fmt.Sprintf("mkdir -p %s", b.filePath["terraformInstallDir"]))
@@ -136,73 +195,28 @@ func initBLXHost(b *blx) error {
 		return fmt.Errorf("Host Initialization Failed.Error -\n%v", err)
 	}
 	initBLXVar(b)
-	updateDist(b)
+	return nil
+}
+
+func initBLXHost(b *blx) error {
+	err := initHostDir(b)
+	if err != nil {
+		return err
+	}
+	// kept for the steps that install packages, the others run on any host
+	b.pkgErr = updateDistro(b)
 	printDebugHostInfo(b)
 	return nil
 }
 
 func initMLX(b *blx) error {
-	execSudoCmdHost(b, fmt.Sprintf("rm -rf %s; mkdir -p %s", b.filePath["mlxDir"], b.filePath["mlxDir"]))
+	execSudoCmdHost(b, fmt.Sprintf("mkdir -p %s", b.filePath["mlxDir"]))
 
 	if b.mlx["ofed"] != "" {
-		err := getFile(b.hostSession, b.mlx["ofed"], b.filePath["mlxDir"])
-		if err != nil {
-			return err
-		}
-
-		if strings.HasSuffix(b.mlx["ofed"], ".gz") {
-			_, err := execSudoCmdHost(b, fmt.Sprintf("gunzip %s/%s", b.filePath["mlxDir"], filepath.Base(b.mlx["ofed"])))
-			if err != nil {
-				return err
-			}
-			b.mlx["ofed"] = strings.TrimSuffix(b.mlx["ofed"], ".gz")
-		}
-		execSudoCmdHost(b, "umount -f /mnt/mlnxofedinstall")
-
-		// mount the mellanox OFED iso
-		_, err = execSudoCmdHost(b, fmt.Sprintf("mount -o ro,loop %s/%s /mnt", b.filePath["mlxDir"], filepath.Base(b.mlx["ofed"])))
+		err := installOFED(b)
 		if err != nil {
 			return err
 		}
-
-		// install kernel headers
-		var instCmd string
-		var pkgList []string
-		if b.dist == distRPM {
-			instCmd = "yum install -y "
-			pkgList = []string{
-				"\"kernel-devel-uname-r == $(uname -r)\"",
-			}
-		} else {
-			instCmd = "apt install -y "
-			pkgList = []string{
-				"linux-headers-`uname -r`",
-			}
-		}
-		for _, pkg := range pkgList {
-			execCmdHost(b, fmt.Sprintf("%s %s", instCmd, pkg))
-		}
-
-		// run the install
-		ofedInstallCmd := "/mnt/mlnxofedinstall --add-kernel-support --skip-repo --skip-distro-check --skip-unsupported-devices-check"
-		out, err := execSudoCmdHost(b, ofedInstallCmd)
-		if err != nil {
-			log.Printf("[WARN]  citrixblx-provider: output = %s", out)
-			if strings.Contains(out, "Current operation system is not supported") {
-				id, err1 := execSudoCmdHost(b, "source /etc/os-release && echo \\$ID")
-				version, err2 := execSudoCmdHost(b, "source /etc/os-release && echo \\$VERSION_ID")
-				if err1 != nil || err2 != nil || len(strings.TrimSpace(id)) == 0 || len(strings.TrimSpace(version)) == 0 {
-					log.Printf("[ERROR] Unable to detect OS distro for OFED Installation. Output of OFED Installation =\r\n%v", out)
-					return err
-				}
-				_, err = execSudoCmdHost(b, fmt.Sprintf("%s --distro %s%s", ofedInstallCmd, strings.TrimSpace(id), strings.TrimSpace(version)))
-				if err != nil {
-					return err
-				}
-			} else {
-				return err
-			}
-		}
 	}
 
 	// restart driver
@@ -213,6 +227,7 @@ func initMLX(b *blx) error {
 
 	if b.mlx["tools"] != "" {
 		// copy tools
+		execSudoCmdHost(b, fmt.Sprintf("rm -rf %s/tools", b.filePath["mlxDir"]))
 		err = getFile(b.hostSession, b.mlx["tools"], fmt.Sprintf("%s/tools", b.filePath["mlxDir"]))
 		if err != nil {
 			return err
@@ -231,42 +246,73 @@ func initMLX(b *blx) error {
 		execSudoCmdHost(b, "mst status -v")
 	}
 
-	return nil
+	return rebootIfRequired(b)
 }
 
 func setupBLX(b *blx) error {
-	err := installBLX(b)
+	var err error
+	b.preflight, err = runPreflight(b)
+	if err != nil {
+		return err
+	}
+
+	err = checkBLXLicenses(b)
+	if err != nil {
+		return err
+	}
+
+	err = runPhase(b, phaseInstall, installInputs(b), blxServiceInstalled, installBLX)
 	if err != nil {
 		return err
 	}
 	log.Printf("[INFO]  citrixblx-provider: Installation of BLX for BLX %s SUCCESS", b.id)
 
-	// BLX shouldn't start on host reboot
-	execSudoCmdHost(b, "systemctl disable blx")
+	err = runPhase(b, phaseHostSetup, phaseHash(phaseHostSetup), nil, func(b *blx) error {
+		// BLX shouldn't start on host reboot
+		execSudoCmdHost(b, "systemctl disable blx")
 
-	enableCoreDumps(b)
+		enableCoreDumps(b)
 
-	enableRsyslog(b)
+		enableRsyslog(b)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
 
 	// clear previous present config
-	execSudoCmdHost(b, "rm -f /nsconfig/ns.conf*")
-	execSudoCmdHost(b, "rm -f /configdb/nscfg.db")
-	execSudoCmdHost(b, "rm -f /var/clusterd/*")
+	err = runPhase(b, phaseClearConfig, phaseHash(phaseClearConfig), nil, func(b *blx) error {
+		execSudoCmdHost(b, "rm -f /nsconfig/ns.conf*")
+		execSudoCmdHost(b, "rm -f /configdb/nscfg.db")
+		execSudoCmdHost(b, "rm -f /var/clusterd/*")
+		return nil
+	})
+	if err != nil {
+		return err
+	}
 
 	// install mlx ofed and mst tools
 	if b.mlx["ofed"] != "" || b.mlx["tools"] != "" {
-		err = initMLX(b)
+		err = runPhase(b, phaseMLX, phaseHash(fileInputID(b.mlx["ofed"]), fileInputID(b.mlx["tools"])), nil, initMLX)
 		if err != nil {
 			return err
 		}
 	}
 
+	err = configureMLXFirmware(b)
+	if err != nil {
+		return err
+	}
+
 	err = initBLX(b)
 	if err != nil {
 		return err
 	}
 	log.Printf("[INFO]  citrixblx-provider: Initialization of BLX for BLX %s SUCCESS", b.id)
 
+	// the journal only resumes a failed create, a later create on
+	// this host runs every phase again
+	journalReset(b)
 	return nil
 }
 
@@ -277,6 +323,14 @@ func destroyBLX(b *blx) error {
 	}
 	log.Printf("[INFO]  citrixblx-provider: Stopping of BLX %s SUCCESS", b.id)
 
+	err = removeSRIOV(b)
+	if err != nil {
+		return err
+	}
+
+	// a later create starts over on a clean host
+	journalReset(b)
+
 	//	err = uninstallBLX(b)
 	//	if err != nil {
 	//		return err
@@ -285,36 +339,13 @@ func destroyBLX(b *blx) error {
 	return nil
 }
 
-func updateDist(b *blx) error {
-	distMap := map[string]string{
-		"yum":     distRPM,
-		"apt-get": distDEB,
-	}
-	for cmd, dist := range distMap {
-		out, err := execCmdHost(b, fmt.Sprintf("which %s > /dev/null ; echo $?", cmd))
-		if err != nil {
-			log.Printf("[ERROR]  citrixblx-provider: Error occured while getting distribution")
-			return fmt.Errorf("Failed to get distribution.\r\n%v", err)
-		}
-		if strings.Contains(out, "0") {
-			b.dist = dist
-			return nil
-		}
-	}
-
-	err := fmt.Errorf("Unknown OS distribution")
-	log.Printf("[ERROR]  citrixblx-provider:", err)
-	return err
-}
-
 func uninstallBLX(b *blx) error {
-	var err error
-	stopBLX(b)
-	if b.dist == distRPM {
-		execSudoCmdHost(b, "yum remove -y blx")
-	} else {
-		execSudoCmdHost(b, "apt-get -y purge blx")
+	err := requirePkgManager(b)
+	if err != nil {
+		return err
 	}
+	stopBLX(b)
+	execPkgCmdHost(b, b.pkg.remove("blx"))
 
 	out, err := execSudoCmdHost(b, "systemctl status blx >/dev/null ; echo \\$\\?")
 	if err != nil {
@@ -323,7 +354,7 @@ func uninstallBLX(b *blx) error {
 	}
 	if strings.Contains(out, "0") {
 		err = fmt.Errorf("BLX package exists after un-installation")
-		log.Printf("[ERROR]  citrixblx-provider:", err)
+		log.Printf("[ERROR]  citrixblx-provider: %v", err)
 		return err
 	}
 
@@ -336,8 +367,8 @@ func uninstallBLX(b *blx) error {
 }
 
 func installEPEL(b *blx) error {
-	execCmdHost(b, "yum search epel-release")
-	out, err := execSudoCmdHost(b, "yum search epel-release | awk '{print \\$1}' | grep epel-release")
+	execCmdHost(b, b.pkg.search("epel-release"))
+	out, err := execPkgCmdHost(b, fmt.Sprintf("%s | awk '{print \\$1}' | grep epel-release", b.pkg.search("epel-release")))
 	if err != nil {
 		return err
 	}
@@ -352,7 +383,7 @@ func installEPEL(b *blx) error {
 		if len(pkg) == 0 {
 			continue
 		}
-		_, err := execSudoCmdHost(b, fmt.Sprintf("yum install -y %s", pkg))
+		_, err := execPkgCmdHost(b, b.pkg.install(pkg))
 		if err == nil {
 			flag = true
 		}
@@ -363,11 +394,76 @@ func installEPEL(b *blx) error {
 	return fmt.Errorf("Unable to successfully install any epel-release package. Package List=\n%s", out)
 }
 
+// install the dependency packages from the uploaded bundle,
+// without reaching out to the configured online repositories
+func installDependencyBundle(b *blx) error {
+	execSudoCmdHost(b, fmt.Sprintf("rm -rf %s", b.filePath["depBundlePath"]))
+	err := getFile(b.hostSession, b.depBundle, b.filePath["depBundlePath"])
+	if err != nil {
+		return fmt.Errorf("Unable to get dependency bundle from %s.\r\n%v", b.depBundle, err)
+	}
+
+	_, err = execCmdHost(b, fmt.Sprintf("cd %s ; tar xzf *", b.filePath["depBundlePath"]))
+	if err != nil {
+		return fmt.Errorf("Error occurred while extracting dependency bundle %s.\r\n%v", b.depBundle, err)
+	}
+
+	out, err := execCmdHost(b, fmt.Sprintf("find %s -name '*.%s' | wc -l", b.filePath["depBundlePath"], b.dist))
+	if err != nil {
+		return err
+	}
+	if strings.TrimSpace(out) == "0" {
+		return fmt.Errorf("Dependency bundle %s does not contain any %s packages", b.depBundle, b.dist)
+	}
+
+	pkgs := fmt.Sprintf("\\$(find %s -name '*.%s')", b.filePath["depBundlePath"], b.dist)
+	_, err = execPkgCmdHost(b, b.pkg.installLocal(pkgs))
+	if err != nil {
+		return fmt.Errorf("Error occurred while installing dependency bundle %s.\r\n%v", b.depBundle, err)
+	}
+	log.Printf("[INFO]  citrixblx-provider: Dependency bundle %s installed on %s", b.depBundle, b.host["ipaddress"])
+	return nil
+}
+
+// install the BLX packages extracted in installDir, downgrade or
+// reinstall when the same or a newer version is already installed
+func installBLXPackages(b *blx, installDir string) error {
+	pkgDir := fmt.Sprintf("cd %s ; cd \\\"\\$(ls -rlth | grep ^d | awk '{print \\$9}')\\\"", installDir)
+	pkgs := fmt.Sprintf("./*.%s", b.dist)
+	_, err := execPkgCmdHost(b, fmt.Sprintf("%s ; %s", pkgDir, b.pkg.install(pkgs)))
+	if err != nil {
+		_, err1 := execPkgCmdHost(b, fmt.Sprintf("%s ; %s", pkgDir, b.pkg.downgrade(pkgs)))
+		_, err2 := execPkgCmdHost(b, fmt.Sprintf("%s ; %s", pkgDir, b.pkg.reinstall(pkgs)))
+		if err1 != nil && err2 != nil {
+			return fmt.Errorf("Error occurred while installing BLX. Error-\r\n%v", err)
+		}
+	}
+	return nil
+}
+
 func installBLX(b *blx) error {
-	err := stopBLX(b)
+	// host distribution is unknown when connected through NS
+	distKnown := b.dist != ""
+	err := checkBLXSource(b)
+	if err != nil {
+		return err
+	}
+
+	err = stopBLX(b)
+	if err != nil {
+		return err
+	}
+
+	err = requirePkgManager(b)
 	if err != nil {
 		return err
 	}
+	if !distKnown {
+		err = checkBLXSource(b)
+		if err != nil {
+			return err
+		}
+	}
 
 	execSudoCmdHost(b, fmt.Sprintf("rm -rf %s/*", b.filePath["blxInstallPath"]))
 	err = getFile(b.hostSession, b.source, b.filePath["blxInstallPath"])
@@ -376,37 +472,41 @@ func installBLX(b *blx) error {
 	}
 	log.Printf("[INFO]  citrixblx-provider: Copy of BLX packages for BLX %s SUCCESS", b.id)
 
-	var cmd string
-	if b.dist == distRPM {
-		err := installEPEL(b)
-		if err != nil {
-			log.Printf("[ERROR] citrixblx-provider: Error encountered while installing dependent package - epel-release")
-		}
-		cmd = fmt.Sprintf("cd %s ; tar xzf * ; cd \\\"\\$(ls -rlth | grep ^d | awk '{print \\$9}')\\\" ; yum install -y *.rpm", b.filePath["blxInstallPath"])
-		_, err = execSudoCmdHost(b, cmd)
+	_, err = execSudoCmdHost(b, fmt.Sprintf("cd %s ; tar xzf *", b.filePath["blxInstallPath"]))
+	if err != nil {
+		return fmt.Errorf("Error occurred while extracting BLX packages. Error-\r\n%v", err)
+	}
+
+	if b.depBundle != "" {
+		err = installDependencyBundle(b)
 		if err != nil {
-			cmd = fmt.Sprintf("cd %s ; cd \\\"\\$(ls -rlth | grep ^d | awk '{print \\$9}')\\\" ; yum downgrade -y *.rpm", b.filePath["blxInstallPath"])
-			_, err1 := execSudoCmdHost(b, cmd)
-			cmd = fmt.Sprintf("cd %s ; cd \\\"\\$(ls -rlth | grep ^d | awk '{print \\$9}')\\\" ; yum reinstall -y *.rpm", b.filePath["blxInstallPath"])
-			_, err2 := execSudoCmdHost(b, cmd)
-			if err1 != nil && err2 != nil {
-				return fmt.Errorf("Error occurred while installing BLX. Error-\r\n%v", err)
-			}
+			return err
 		}
-	} else {
-		cmd = fmt.Sprintf("cd %s ; tar xzf * ; cd \\\"\\$(ls -rlth | grep ^d | awk '{print \\$9}')\\\" ; apt install -y -o Dpkg::Options::=\\\"--force-confold\\\" --allow-downgrades ./*.deb", b.filePath["blxInstallPath"])
-		_, err := execSudoCmdHost(b, cmd)
+	}
+
+	if b.enableEPEL && (b.pkg.name() == pkgDNF || b.pkg.name() == pkgYUM) {
+		err := installEPEL(b)
 		if err != nil {
-			return fmt.Errorf("Error occurred while installing BLX. Error-\r\n%v", err)
+			log.Printf("[ERROR] citrixblx-provider: Error encountered while installing dependent package - epel-release")
 		}
 	}
 
+	err = installBLXPackages(b, b.filePath["blxInstallPath"])
+	if err != nil {
+		return err
+	}
+
 	// check blx service is present
 	_, err = execSudoCmdHost(b, "systemctl list-unit-files | grep -q blx.service")
 	if err != nil {
 		log.Printf("[ERROR]  citrixblx-provider: systemctl check after installation failed")
 		return fmt.Errorf("Error occurred while installing blx.\r\n%v\nBLX Installation Failed", err)
 	}
+
+	out, err := execPkgCmdHost(b, b.pkg.queryVersion("blx"))
+	if err == nil {
+		log.Printf("[INFO]  citrixblx-provider: BLX package version %s installed on %s", strings.TrimSpace(out), b.host["ipaddress"])
+	}
 	return nil
 }
 
@@ -464,15 +564,15 @@ func createStartScript(b *blx) error {
 func startBLX(b *blx) error {
 	_, err := execSudoCmdHost(b, fmt.Sprintf("nohup bash %s > %s 2>&1 &", b.filePath["blxStartScript"], b.filePath["blxStartLog"]))
 	if err != nil {
-		fmt.Errorf("Error occurred while starting blx.\r\n%v", err)
+		return fmt.Errorf("Error occurred while starting blx.\r\n%v", err)
 	}
-	time.Sleep(time.Second * 10)
+	sleep(time.Second * 10)
 
 	err = checkBLXIP(b)
 	if err != nil {
 		return err
 	}
-	return nil
+	return undrainBLX(b)
 }
 
 func restartBLX(b *blx) error {
@@ -516,12 +616,54 @@ func copyLicense(b *blx) error {
 	return nil
 }
 
+// check the local licenses before BLX is stopped for them
+func checkBLXLicenses(b *blx) error {
+	if len(b.licenseList) == 0 {
+		return nil
+	}
+	features, err := validateLicenses(b, b.licenseList, b.licenseCheck)
+	if err != nil {
+		return err
+	}
+	b.licenseFeatures = features
+	return nil
+}
+
+// checks of an update that run before BLX is stopped. Update reaches BLX
+// over the NS CLI, the host is connected for its distribution; when BLX
+// holds the host address the source is checked again by the install
+func checkBLXUpdate(b *blx, sourceChanged bool) error {
+	if sourceChanged {
+		if b.dist == "" {
+			err := ensureHostSession(b)
+			if err != nil {
+				log.Printf("[WARN]  citrixblx-provider: Host %s not reachable, BLX source checked after BLX is stopped. %v", b.host["ipaddress"], err)
+			}
+		}
+		err := checkBLXSource(b)
+		if err != nil {
+			return err
+		}
+	}
+	return checkBLXLicenses(b)
+}
+
 func initBLX(b *blx) error {
 	err := stopBLX(b)
 	if err != nil {
 		return err
 	}
 
+	err = setupSRIOV(b)
+	if err != nil {
+		return err
+	}
+
+	err = updateWorkerCPUs(b)
+	if err != nil {
+		return err
+	}
+
 	// create blx.conf
 	err = createBLXConf(b)
 	if err != nil {
@@ -546,23 +688,17 @@ func initBLX(b *blx) error {
 	}
 
 	// pooled licensing
-	for _, cmd := range b.cliCmd {
-		if strings.Contains(strings.ToLower(cmd), "licenseserver") {
-			log.Printf("[DEBUG]  citrixblx-provider: License Server configuration detected, restarting BLX twice %s", b.id)
-			err = restartBLX(b)
-			if err != nil {
-				return err
-			}
-			err = restartBLX(b)
-			if err != nil {
-				return err
-			}
+	if b.pooledLicense != nil || cliCmdLicenseServer(b) {
+		log.Printf("[DEBUG]  citrixblx-provider: License Server configuration detected, waiting for pooled license %s", b.id)
+		err = waitPooledLicense(b)
+		if err != nil {
+			return err
 		}
 	}
 
 	// sleep needed for cluster, LA scenario's
 	log.Printf("[DEBUG] citrixblx-provider: %s is reachable, sleeping for 90 secs to ensure ports are UP", b.id)
-	time.Sleep(90 * time.Second)
+	sleep(90 * time.Second)
 	return nil
 }
 
@@ -605,7 +741,7 @@ func checkBLXProcess(b *blx) error {
 			log.Printf("[DEBUG]  citrixblx-provider: BLX Processes on Host SUCCESS")
 			return nil
 		}
-		time.Sleep(time.Second * 2)
+		sleep(time.Second * 2)
 	}
 
 	return fmt.Errorf("BLX processes did not come up Host after 2 mins")
@@ -621,12 +757,12 @@ func checkBLXIP(b *blx) error {
 	}
 
 	for i := 1; i < 100; i++ {
-		_, err := net.DialTimeout("tcp", net.JoinHostPort(b.id, mgmtPort), time.Second*2)
+		_, err := dialTimeout("tcp", net.JoinHostPort(b.id, mgmtPort), time.Second*2)
 		if err == nil {
 			log.Printf("[INFO]  citrixblx-provider: %s:%s is reachable now SUCCESS", b.id, mgmtPort)
 			return nil
 		}
-		time.Sleep(2 * time.Second)
+		sleep(2 * time.Second)
 		if i%4 == 0 {
 			log.Printf("[WARN]  citrixblx-provider: %s:%s is not reachable, waiting", b.id, mgmtPort)
 		}
@@ -653,29 +789,36 @@ func createStopScript(b *blx) error {
 }
 
 func stopBLX(b *blx) error {
+	err := drainBLX(b)
+	if err != nil {
+		return err
+	}
+
 	// stop BLX for destroy
 	if b.nsSession != nil {
 		runNSShellCmd(b.nsSession, "systemctl stop blx")
 		b.nsSession = nil
 	}
-	time.Sleep(time.Second * 5)
+	sleep(time.Second * 5)
 
-	// re-connect since maybe previously in management mode
-	var err error
+	// re-connect since maybe previously in management mode, the cached
+	// host connection is reused when still alive
 	b.hostSession, err = hostConnect(b.host)
 	if err != nil {
 		return fmt.Errorf("Error unable to connect back to host after stopping BLX.\r\n%v", err)
 	}
-	err = initBLXHost(b)
-	if err != nil {
-		return fmt.Errorf("Unable to initialize host.\r\n%v", err)
+	if b.filePath == nil {
+		err = initBLXHost(b)
+		if err != nil {
+			return fmt.Errorf("Unable to initialize host.\r\n%v", err)
+		}
 	}
 
 	// re-run the command for terraform install scenario
 	execSudoCmdHost(b, "systemctl stop blx")
 	_, err = execSudoCmdHost(b, fmt.Sprintf("nohup bash %s > %s 2>&1 &", b.filePath["blxStopScript"], b.filePath["blxStopLog"]))
 	if err != nil {
-		fmt.Errorf("Error running BLX stop script.\r\n%v", err)
+		log.Printf("[WARN]  citrixblx-provider: Error running BLX stop script.\r\n%v", err)
 	}
 	err = checkBLXStop(b)
 	if err != nil {
@@ -725,6 +868,10 @@ func genBLXCLICmdBlock(b *blx) []string {
 		cmdList = append(cmdList, cmd)
 	}
 
+	for _, cmd := range genPooledLicenseCmds(b.pooledLicense) {
+		cmdList = append(cmdList, cmd)
+	}
+
 	if b.password != "" {
 		cmdList = append(cmdList, fmt.Sprintf("set system user nsroot -password %s", b.password))
 	}
@@ -0,0 +1,727 @@
+package citrixblx
+
+import (
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestInitBLXHost(t *testing.T) {
+	f := newFakeTransport()
+	b := newTestBLX(t, f)
+
+	if b.filePath["terraformInstallDir"] != "/home/user/.terraform_blx" {
+		t.Errorf("terraformInstallDir = %q", b.filePath["terraformInstallDir"])
+	}
+	if b.filePath["blxInstallPath"] != "/home/user/.terraform_blx/blx_install" {
+		t.Errorf("blxInstallPath = %q", b.filePath["blxInstallPath"])
+	}
+	if b.pkg.name() != pkgAPT || b.dist != distDEB {
+		t.Errorf("package manager = %s, dist = %s, want apt and deb", b.pkg.name(), b.dist)
+	}
+}
+
+func TestInitBLXHostUnsupportedDistro(t *testing.T) {
+	f := newFakeTransport()
+	f.on("cat /etc/os-release", "ID=plan9\nVERSION_ID=4")
+	// destroy works on any host, only the install steps need a package manager
+	b := newTestBLX(t, f)
+	if b.pkg != nil {
+		t.Fatalf("package manager = %s, want none", b.pkg.name())
+	}
+
+	_, err := runPreflight(b)
+	if err == nil || !strings.Contains(err.Error(), "Unsupported OS distribution") {
+		t.Fatalf("runPreflight error = %v, want unsupported distribution", err)
+	}
+	err = installBLX(b)
+	if err == nil || !strings.Contains(err.Error(), "Unsupported OS distribution") {
+		t.Fatalf("installBLX error = %v, want unsupported distribution", err)
+	}
+	err = destroyBLX(b)
+	if err != nil {
+		t.Fatalf("destroyBLX: %v", err)
+	}
+}
+
+func TestSelectPkgManager(t *testing.T) {
+	tests := []struct {
+		id, idLike, version string
+		want                string
+	}{
+		{"ubuntu", "debian", "20.04", pkgAPT},
+		{"rhel", "fedora", "9.2", pkgDNF},
+		{"centos", "rhel fedora", "7", pkgYUM},
+		{"rocky", "rhel centos fedora", "8.8", pkgDNF},
+		{"fedora", "", "38", pkgDNF},
+		{"sles", "suse", "15.4", pkgZypper},
+		{"amzn", "centos rhel fedora", "2", ""},
+		{"amzn", "fedora", "2023", ""},
+		{"centos", "rhel fedora", "6", ""},
+	}
+	for _, tt := range tests {
+		pkg, err := selectPkgManager(tt.id, tt.idLike, tt.version)
+		name := ""
+		if err == nil {
+			name = pkg.name()
+		}
+		if name != tt.want {
+			t.Errorf("selectPkgManager(%s, %s, %s) = %s, %v, want %s", tt.id, tt.idLike, tt.version, name, err, tt.want)
+		}
+	}
+}
+
+func TestExecPkgCmdHostLockTimeout(t *testing.T) {
+	f := newFakeTransport()
+	f.fail("--allow-downgrades vim", "E: Could not get lock /var/lib/dpkg/lock-frontend")
+	b := newTestBLX(t, f)
+	b.pkgLockTimeout = 2 * time.Minute
+
+	var waited time.Duration
+	sleep = func(d time.Duration) { waited += d }
+	_, err := execPkgCmdHost(b, b.pkg.install("vim"))
+	if err == nil || !strings.Contains(err.Error(), "lock still held") {
+		t.Fatalf("expected lock timeout, got %v", err)
+	}
+	if waited != 75*time.Second {
+		t.Errorf("waited %v for the lock, want 1m15s", waited)
+	}
+}
+
+func TestInstallBLX(t *testing.T) {
+	f := newFakeTransport()
+	b := newTestBLX(t, f)
+
+	err := installBLX(b)
+	if err != nil {
+		t.Fatalf("installBLX: %v", err)
+	}
+
+	stop := f.ran("systemctl stop blx")
+	download := f.ran("curl -k -O https://example.com/blx-deb-13.1-37.38.tar.gz")
+	extract := f.ran("cd /home/user/.terraform_blx/blx_install ; tar xzf *")
+	install := f.ran("apt install -y")
+	check := f.ran("systemctl list-unit-files | grep -q blx.service")
+	if stop < 0 || download < 0 || extract < 0 || install < 0 || check < 0 {
+		t.Fatalf("missing install steps, commands:\n%s", strings.Join(f.cmds, "\n"))
+	}
+	if !(stop < download && download < extract && extract < install && install < check) {
+		t.Errorf("install steps out of order, commands:\n%s", strings.Join(f.cmds, "\n"))
+	}
+	if f.ran("--reinstall") >= 0 {
+		t.Errorf("reinstall run although install succeeded")
+	}
+}
+
+func TestInstallBLXDowngrade(t *testing.T) {
+	f := newFakeTransport()
+	// a newer BLX is installed, the install fails and the downgrade succeeds
+	f.failTimes("--allow-downgrades ./*.deb", "newer version installed", 1)
+	b := newTestBLX(t, f)
+
+	err := installBLX(b)
+	if err != nil {
+		t.Fatalf("installBLX: %v", err)
+	}
+	if f.ran("--reinstall") < 0 {
+		t.Errorf("reinstall not tried after failed install")
+	}
+}
+
+func TestInstallBLXPackagesFail(t *testing.T) {
+	f := newFakeTransport()
+	f.fail("apt install", "E: broken packages")
+	b := newTestBLX(t, f)
+
+	err := installBLX(b)
+	if err == nil || !strings.Contains(err.Error(), "Error occurred while installing BLX") {
+		t.Fatalf("installBLX error = %v, want install error", err)
+	}
+	if f.ran("systemctl list-unit-files") >= 0 {
+		t.Errorf("service checked after failed install")
+	}
+}
+
+func TestInstallBLXExtractFail(t *testing.T) {
+	f := newFakeTransport()
+	f.fail("tar xzf", "gzip: stdin: not in gzip format")
+	b := newTestBLX(t, f)
+
+	err := installBLX(b)
+	if err == nil || !strings.Contains(err.Error(), "extracting BLX packages") {
+		t.Fatalf("installBLX error = %v, want extract error", err)
+	}
+	if f.ran("apt install") >= 0 {
+		t.Errorf("packages installed after failed extract")
+	}
+}
+
+func TestInstallBLXServiceMissing(t *testing.T) {
+	f := newFakeTransport()
+	f.fail("systemctl list-unit-files", "")
+	b := newTestBLX(t, f)
+
+	err := installBLX(b)
+	if err == nil || !strings.Contains(err.Error(), "BLX Installation Failed") {
+		t.Fatalf("installBLX error = %v, want missing service error", err)
+	}
+}
+
+func TestInstallBLXStillRunning(t *testing.T) {
+	f := newFakeTransport()
+	f.on("/usr/sbin/nsppe", "4")
+	b := newTestBLX(t, f)
+
+	err := installBLX(b)
+	if err == nil || !strings.Contains(err.Error(), "still running") {
+		t.Fatalf("installBLX error = %v, want BLX still running", err)
+	}
+	if f.ran("curl -k -O") >= 0 {
+		t.Errorf("source downloaded while BLX still running")
+	}
+}
+
+func TestInstallBLXDownloadFail(t *testing.T) {
+	f := newFakeTransport()
+	f.fail("curl -k -O", "curl: (6) Could not resolve host")
+	b := newTestBLX(t, f)
+
+	err := installBLX(b)
+	if err == nil || !strings.Contains(err.Error(), "Unable to get BLX Install Packages") {
+		t.Fatalf("installBLX error = %v, want download error", err)
+	}
+}
+
+func TestInstallBLXDependencyBundle(t *testing.T) {
+	f := newFakeTransport()
+	f.on("-name '*.deb' | wc -l", "3")
+	b := newTestBLX(t, f)
+	b.depBundle = "https://example.com/deps.tar.gz"
+
+	err := installBLX(b)
+	if err != nil {
+		t.Fatalf("installBLX: %v", err)
+	}
+	bundle := f.ran("find /home/user/.terraform_blx/dependency_bundle -name '*.deb')")
+	blx := f.ran("apt install -y -o Dpkg::Options::=\"--force-confold\" --allow-downgrades ./*.deb")
+	if bundle < 0 || blx < 0 || bundle > blx {
+		t.Errorf("dependency bundle not installed before BLX, commands:\n%s", strings.Join(f.cmds, "\n"))
+	}
+}
+
+func TestInstallBLXDependencyBundleEmpty(t *testing.T) {
+	f := newFakeTransport()
+	f.on("-name '*.deb' | wc -l", "0")
+	b := newTestBLX(t, f)
+	b.depBundle = "https://example.com/deps.tar.gz"
+
+	err := installBLX(b)
+	if err == nil || !strings.Contains(err.Error(), "does not contain any deb packages") {
+		t.Fatalf("installBLX error = %v, want empty bundle error", err)
+	}
+}
+
+func TestUninstallBLX(t *testing.T) {
+	f := newFakeTransport()
+	f.on("systemctl status blx", "4")
+	b := newTestBLX(t, f)
+
+	err := uninstallBLX(b)
+	if err != nil {
+		t.Fatalf("uninstallBLX: %v", err)
+	}
+	if f.ran("apt-get -y purge blx") < 0 {
+		t.Errorf("blx package not removed")
+	}
+	if f.ran("rm -rf /home/user/.terraform_blx/*") < 0 {
+		t.Errorf("install directory not cleared")
+	}
+}
+
+func TestUninstallBLXPackageLeft(t *testing.T) {
+	f := newFakeTransport()
+	f.on("systemctl status blx", "0")
+	b := newTestBLX(t, f)
+
+	err := uninstallBLX(b)
+	if err == nil || !strings.Contains(err.Error(), "exists after un-installation") {
+		t.Fatalf("uninstallBLX error = %v, want package left", err)
+	}
+	if f.ran("rm -rf /home/user/.terraform_blx/*") >= 0 {
+		t.Errorf("install directory cleared although package is left")
+	}
+}
+
+func TestUninstallBLXStatusFail(t *testing.T) {
+	f := newFakeTransport()
+	f.fail("systemctl status blx", "")
+	b := newTestBLX(t, f)
+
+	err := uninstallBLX(b)
+	if err == nil || !strings.Contains(err.Error(), "un-installing blx") {
+		t.Fatalf("uninstallBLX error = %v, want status error", err)
+	}
+}
+
+func TestInitBLX(t *testing.T) {
+	f := newFakeTransport()
+	b := newTestBLX(t, f)
+
+	err := initBLX(b)
+	if err != nil {
+		t.Fatalf("initBLX: %v", err)
+	}
+
+	conf := f.ran("/etc/blx/blx.conf")
+	start := f.ran("nohup bash /home/user/.terraform_blx/blx_start.sh")
+	if conf < 0 || start < 0 || conf > start {
+		t.Errorf("blx.conf not written before BLX start, commands:\n%s", strings.Join(f.cmds, "\n"))
+	}
+	if f.ran("systemctl restart blx") < 0 {
+		t.Errorf("start script not created")
+	}
+	if len(f.copies) == 0 {
+		t.Errorf("blx.conf not copied to the host")
+	}
+}
+
+func TestInitBLXUnreachable(t *testing.T) {
+	f := newFakeTransport()
+	b := newTestBLX(t, f)
+	f.unreachable = true
+
+	err := initBLX(b)
+	if err == nil || !strings.Contains(err.Error(), "BLX not reachable on 10.0.0.10:9022") {
+		t.Fatalf("initBLX error = %v, want BLX not reachable", err)
+	}
+}
+
+func TestInitBLXStartFail(t *testing.T) {
+	f := newFakeTransport()
+	f.fail("nohup bash /home/user/.terraform_blx/blx_start.sh", "")
+	b := newTestBLX(t, f)
+
+	err := initBLX(b)
+	if err == nil || !strings.Contains(err.Error(), "starting blx") {
+		t.Fatalf("initBLX error = %v, want start error", err)
+	}
+}
+
+func TestInitBLXConfFail(t *testing.T) {
+	f := newFakeTransport()
+	f.fail("> /etc/blx/blx.conf", "Permission denied")
+	b := newTestBLX(t, f)
+
+	err := initBLX(b)
+	if err == nil || !strings.Contains(err.Error(), "blx config file") {
+		t.Fatalf("initBLX error = %v, want blx.conf error", err)
+	}
+	if f.ran("blx_start.sh >") >= 0 {
+		t.Errorf("BLX started without blx.conf")
+	}
+}
+
+func TestInitBLXLicenseCopyFail(t *testing.T) {
+	f := newFakeTransport()
+	f.fail("curl -k -O https://example.com/blx.lic", "")
+	b := newTestBLX(t, f)
+	b.licenseList = []string{"https://example.com/blx.lic"}
+	b.licenseCheck = licenseCheckOff
+
+	err := initBLX(b)
+	if err == nil || !strings.Contains(err.Error(), "Error copying license file") {
+		t.Fatalf("initBLX error = %v, want license copy error", err)
+	}
+}
+
+func TestValidateLicensesConnectsHost(t *testing.T) {
+	f := newFakeTransport()
+	b := newTestBLX(t, f)
+	// update reached BLX over the NS CLI, no host session yet
+	b.hostSession = nil
+	file := filepath.Join(t.TempDir(), "blx.lic")
+	err := ioutil.WriteFile(file, []byte("INCREMENT CNS_V10000_SERVER CITRIX 2030.0101 permanent 1 HOSTID=001122334455\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = validateLicenses(b, []string{file}, licenseCheckWarn)
+	if err != nil {
+		t.Fatalf("validateLicenses: %v", err)
+	}
+	if b.hostSession == nil || f.ran("/sys/class/net") < 0 {
+		t.Fatalf("host interfaces not read, commands %v", f.cmds)
+	}
+}
+
+func TestInitMLX(t *testing.T) {
+	f := newFakeTransport()
+	b := newTestBLX(t, f)
+	b.mlx["tools"] = "https://example.com/mft-4.22.tgz"
+
+	err := initMLX(b)
+	if err != nil {
+		t.Fatalf("initMLX: %v", err)
+	}
+	restart := f.ran("/etc/init.d/openibd restart")
+	install := f.ran("./install.sh")
+	mst := f.ran("mst start")
+	if restart < 0 || install < 0 || mst < 0 || !(restart < install && install < mst) {
+		t.Errorf("mlx steps missing or out of order, commands:\n%s", strings.Join(f.cmds, "\n"))
+	}
+}
+
+func TestInitMLXDriverRestartFail(t *testing.T) {
+	f := newFakeTransport()
+	f.fail("/etc/init.d/openibd restart", "openibd: not found")
+	b := newTestBLX(t, f)
+	b.mlx["tools"] = "https://example.com/mft-4.22.tgz"
+
+	err := initMLX(b)
+	if err == nil {
+		t.Fatalf("initMLX succeeded, want driver restart error")
+	}
+	if f.ran("./install.sh") >= 0 {
+		t.Errorf("tools installed after failed driver restart")
+	}
+}
+
+func TestInitMLXToolsInstallFail(t *testing.T) {
+	f := newFakeTransport()
+	f.fail("./install.sh", "unsupported kernel")
+	b := newTestBLX(t, f)
+	b.mlx["tools"] = "https://example.com/mft-4.22.tgz"
+
+	err := initMLX(b)
+	if err == nil || !strings.Contains(err.Error(), "install.sh") {
+		t.Fatalf("initMLX error = %v, want tools install error", err)
+	}
+	if f.ran("mst start") >= 0 {
+		t.Errorf("mst started after failed tools install")
+	}
+}
+
+func TestInitMLXOFEDInstalled(t *testing.T) {
+	f := newFakeTransport()
+	f.on("ofed_info -s", "MLNX_OFED_LINUX-5.8-1.1.2.1:")
+	b := newTestBLX(t, f)
+	b.mlx["ofed"] = "https://example.com/MLNX_OFED_LINUX-5.8-1.1.2.1-ubuntu20.04-x86_64.iso"
+
+	err := initMLX(b)
+	if err != nil {
+		t.Fatalf("initMLX: %v", err)
+	}
+	if f.ran("mount -o ro,loop") >= 0 {
+		t.Errorf("OFED installed again although the same version is installed")
+	}
+}
+
+func TestSetupBLX(t *testing.T) {
+	f := newFakeTransport()
+	b := newTestBLX(t, f)
+
+	err := setupBLX(b)
+	if err != nil {
+		t.Fatalf("setupBLX: %v", err)
+	}
+	for _, phase := range []string{phaseInstall, phaseHostSetup, phaseClearConfig} {
+		if f.ran("echo '"+phase+" ") < 0 {
+			t.Errorf("phase %s not recorded in journal", phase)
+		}
+	}
+	if f.ran("rm -f /nsconfig/ns.conf*") < 0 {
+		t.Errorf("previous config not cleared")
+	}
+	if f.ran("rm -f /home/user/.terraform_blx/journal") < f.ran("nohup bash /home/user/.terraform_blx/blx_start.sh") {
+		t.Errorf("journal not removed after successful create")
+	}
+	if b.preflight["disk_install_dir"] != preflightOK {
+		t.Errorf("preflight = %v", b.preflight)
+	}
+}
+
+func TestSetupBLXPreflightFail(t *testing.T) {
+	f := newFakeTransport()
+	f.on("df -Pk", "1024")
+	b := newTestBLX(t, f)
+
+	err := setupBLX(b)
+	if err == nil || !strings.Contains(err.Error(), "Pre-flight checks failed") {
+		t.Fatalf("setupBLX error = %v, want pre-flight failure", err)
+	}
+	if f.ran("curl -k -O") >= 0 || f.ran("apt install") >= 0 {
+		t.Errorf("host changed after failed pre-flight checks")
+	}
+}
+
+func TestSetupBLXSkipsJournaledPhases(t *testing.T) {
+	f := newFakeTransport()
+	b := newTestBLX(t, f)
+	journal := strings.Join([]string{
+		phaseInstall + " " + installInputs(b),
+		phaseHostSetup + " " + phaseHash(phaseHostSetup),
+		phaseClearConfig + " " + phaseHash(phaseClearConfig),
+	}, "\n")
+	f.on("cat /home/user/.terraform_blx/journal", journal)
+
+	err := setupBLX(b)
+	if err != nil {
+		t.Fatalf("setupBLX: %v", err)
+	}
+	if f.ran("curl -k -O") >= 0 || f.ran("rm -f /nsconfig/ns.conf*") >= 0 || f.ran("systemctl disable blx") >= 0 {
+		t.Errorf("journaled phases run again, commands:\n%s", strings.Join(f.cmds, "\n"))
+	}
+	if f.ran("nohup bash /home/user/.terraform_blx/blx_start.sh") < 0 {
+		t.Errorf("BLX not started")
+	}
+}
+
+func TestSetupBLXReinstallsMissingService(t *testing.T) {
+	f := newFakeTransport()
+	b := newTestBLX(t, f)
+	f.on("cat /home/user/.terraform_blx/journal", phaseInstall+" "+installInputs(b))
+	f.failTimes("systemctl list-unit-files | grep -q blx.service", "", 1)
+
+	err := setupBLX(b)
+	if err != nil {
+		t.Fatalf("setupBLX: %v", err)
+	}
+	if f.ran("curl -k -O https://example.com/blx-deb") < 0 {
+		t.Errorf("BLX not installed again although the service is missing")
+	}
+}
+
+func TestSetupBLXInstallFail(t *testing.T) {
+	f := newFakeTransport()
+	f.fail("apt install", "E: broken packages")
+	b := newTestBLX(t, f)
+
+	err := setupBLX(b)
+	if err == nil {
+		t.Fatalf("setupBLX succeeded, want install error")
+	}
+	if f.ran("echo '"+phaseInstall+" ") >= 0 {
+		t.Errorf("failed install recorded in journal")
+	}
+	if f.ran("rm -f /nsconfig/ns.conf*") >= 0 {
+		t.Errorf("setup continued after failed install")
+	}
+}
+
+func TestWaitPeerUpgradedRolledBack(t *testing.T) {
+	f := newFakeTransport()
+	f.on("show ns version", "NetScaler NS13.1: Build 30.1")
+	f.on("show ha node", "1)\tNode ID: 0\n\tIP: 10.0.0.11\n\tNode State: UP\n\tMaster State: Secondary\n\tSync State: SUCCESS\n"+
+		"2)\tNode ID: 1\n\tIP: 10.0.0.10\n\tNode State: UP\n\tMaster State: Primary\n")
+	b := newTestBLX(t, f)
+	b.source = "https://example.com/blx-deb-13.1-37.38.tar.gz"
+	peer := &blx{id: "10.0.0.11"}
+
+	// the peer is down once for its upgrade and comes back on the old version
+	down := 1
+	nsConnect = func(n *blx) (Transport, error) {
+		if n.id == peer.id && down > 0 {
+			down--
+			return nil, errors.New("connection refused")
+		}
+		return f, nil
+	}
+
+	err := waitPeerUpgraded(b, peer)
+	if err == nil || !strings.Contains(err.Error(), "rolled back") {
+		t.Fatalf("waitPeerUpgraded error = %v, want rolled back error", err)
+	}
+}
+
+func TestReloadLicense(t *testing.T) {
+	f := newFakeTransport()
+	f.on("/usr/sbin/nsppe", "4")
+	b := newTestBLX(t, f)
+
+	err := reloadLicense(b)
+	if err != nil {
+		t.Fatalf("reloadLicense: %v", err)
+	}
+	if f.ran("systemctl restart blx") < 0 {
+		t.Errorf("blx service not restarted, commands %v", f.cmds)
+	}
+	if f.ran("systemctl stop blx") >= 0 || f.ran("show lb vserver") >= 0 {
+		t.Errorf("BLX stopped or drained for a license change, commands %v", f.cmds)
+	}
+}
+
+func TestRebootIfRequiredStartsMST(t *testing.T) {
+	f := newFakeTransport()
+	f.on("boot_id", "boot-2")
+	f.replies = append(f.replies, &fakeReply{match: "boot_id", out: "boot-1", times: 1})
+	b := newTestBLX(t, f)
+	b.mlx["tools"] = "https://example.com/mft-4.22.tgz"
+	b.rebootRequired = true
+	b.rebootIfRequired = true
+	// the host drops off right away after the reboot command
+	f.unreachable = true
+
+	err := rebootIfRequired(b)
+	if err != nil {
+		t.Fatalf("rebootIfRequired: %v", err)
+	}
+	reboot := f.ran("sleep 2 ; reboot")
+	if reboot < 0 {
+		t.Fatalf("host not rebooted, commands %v", f.cmds)
+	}
+	mst := -1
+	for i := reboot; i < len(f.cmds); i++ {
+		if f.cmds[i] == "mst start" {
+			mst = i
+		}
+	}
+	if mst < 0 {
+		t.Errorf("mst not started after the reboot, commands %v", f.cmds)
+	}
+}
+
+func TestSelectWorkerCPUsListSkipFlags(t *testing.T) {
+	// 4 cores with hyperthread siblings 4-7
+	var facts hostFacts
+	for id := 0; id < 8; id++ {
+		facts.cpus = append(facts.cpus, hostCPU{id: id, core: id % 4})
+	}
+
+	tests := []struct {
+		spec                    string
+		skipCore0, skipSiblings bool
+		wantErr                 string
+	}{
+		{"0-2", false, false, ""},
+		{"1,2", true, true, ""},
+		{"0-2", true, false, "worker_cpus_skip_core0"},
+		{"1,4", true, true, "worker_cpus_skip_core0"},
+		{"1,5", false, true, "worker_cpus_skip_siblings"},
+		{"1,5", false, false, ""},
+	}
+	for _, tt := range tests {
+		_, err := selectWorkerCPUs(facts, tt.spec, "", tt.skipCore0, tt.skipSiblings)
+		if tt.wantErr == "" && err != nil || tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+			t.Errorf("selectWorkerCPUs(%s, %t, %t) error = %v, want %s", tt.spec, tt.skipCore0, tt.skipSiblings, err, tt.wantErr)
+		}
+	}
+}
+
+func TestWorkerCoresNeeded(t *testing.T) {
+	tests := []struct {
+		workers string
+		want    int
+	}{
+		{"", 0},
+		{"4", 4},
+		{"-c 0x3", 2},
+		{"-c 0xc", 4},
+		{"-c 0x10000000000000000", 65},
+		{"-c 0xfffffffffffffffffffffffffffffff0", 128},
+	}
+	for _, tt := range tests {
+		got, err := workerCoresNeeded(tt.workers)
+		if err != nil || got != tt.want {
+			t.Errorf("workerCoresNeeded(%s) = %d, %v, want %d", tt.workers, got, err, tt.want)
+		}
+	}
+	if _, err := workerCoresNeeded("-c 0xzz"); err == nil {
+		t.Errorf("workerCoresNeeded accepted an invalid core mask")
+	}
+}
+
+func TestInstallDependencyBundleOffline(t *testing.T) {
+	f := newFakeTransport()
+	f.on("-name '*.deb' | wc -l", "3")
+	b := newTestBLX(t, f)
+	b.depBundle = "https://example.com/deps.tar.gz"
+
+	err := installDependencyBundle(b)
+	if err != nil {
+		t.Fatalf("installDependencyBundle: %v", err)
+	}
+	if f.ran("apt install -y --no-download") < 0 {
+		t.Errorf("bundle not installed without downloads, commands %v", f.cmds)
+	}
+}
+
+func TestCheckBLXUpdateLicenseFail(t *testing.T) {
+	f := newFakeTransport()
+	b := newTestBLX(t, f)
+	// update reached BLX over the NS CLI, no host session yet
+	b.hostSession = nil
+	file := filepath.Join(t.TempDir(), "blx.lic")
+	err := ioutil.WriteFile(file, []byte("INCREMENT CNS_V10000_SERVER CITRIX 2030.0101 permanent 1 HOSTID=001122334455\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.licenseList = []string{file}
+	b.licenseCheck = licenseCheckFail
+
+	err = checkBLXUpdate(b, false)
+	if err == nil || !strings.Contains(err.Error(), "License check failed") {
+		t.Fatalf("checkBLXUpdate error = %v, want license check error", err)
+	}
+	if f.ran("systemctl stop blx") >= 0 {
+		t.Errorf("BLX stopped before the update checks, commands %v", f.cmds)
+	}
+}
+
+func TestUpdateHugepagesUnset(t *testing.T) {
+	f := newFakeTransport()
+	b := newTestBLX(t, f)
+
+	err := updateHugepages(b, hostPrep{hugepageSize: hugepageSize2M})
+	if err != nil {
+		t.Fatalf("updateHugepages: %v", err)
+	}
+	if f.ran("sysctl -w") >= 0 || f.ran("nr_hugepages") >= 0 {
+		t.Errorf("hugepages changed while unset, commands %v", f.cmds)
+	}
+}
+
+func TestPooledLicenseInstanceCount(t *testing.T) {
+	pl := &pooledLicense{server: "10.0.0.5", port: defaultLicenseServerPort, edition: "Platinum", instanceCount: 4}
+	cmds := genPooledLicenseCmds(pl)
+	if len(cmds) != 2 || cmds[1] != "set ns capacity -vcpu -vcpuCount 4 -edition Platinum" {
+		t.Fatalf("genPooledLicenseCmds = %v, want vCPU capacity of 4", cmds)
+	}
+
+	for vcpus, want := range map[string]bool{"": false, "2": false, "4": true, "8": false} {
+		status := map[string]string{"edition": "Platinum", "vcpu_count": vcpus}
+		if got := pooledCapacityApplied(pl, status); got != want {
+			t.Errorf("pooledCapacityApplied with %s vCPUs = %t, want %t", vcpus, got, want)
+		}
+	}
+
+	pl.bandwidth = 1000
+	if validatePooledLicense(pl) == nil {
+		t.Errorf("validatePooledLicense accepted bandwidth with instance_count")
+	}
+}
+
+func TestInstallOFEDHeadersFail(t *testing.T) {
+	f := newFakeTransport()
+	f.fail("linux-headers", "E: Unable to locate package")
+	b := newTestBLX(t, f)
+	b.mlx["ofed"] = "https://example.com/MLNX_OFED_LINUX-5.8-1.1.2.1-ubuntu20.04-x86_64.iso"
+
+	err := installOFED(b)
+	if err == nil || !strings.Contains(err.Error(), "kernel headers") {
+		t.Fatalf("installOFED error = %v, want kernel headers error", err)
+	}
+	if f.ran("curl") >= 0 || len(f.copies) != 0 {
+		t.Errorf("OFED ISO uploaded before the kernel headers were installed, commands %v", f.cmds)
+	}
+}
+
+func TestLocalCopyMissingSource(t *testing.T) {
+	dir := t.TempDir()
+	err := localTransport{}.Copy(filepath.Join(dir, "missing.lic"), dir)
+	if err == nil || !strings.Contains(err.Error(), "Error copying file from") {
+		t.Fatalf("expected wrapped copy error, got %v", err)
+	}
+}
@@ -0,0 +1,351 @@
+package citrixblx
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const (
+	clusterRebootWait       = 30 * time.Second
+	clusterOperationalWait  = 15 * time.Minute
+	clusterCoordinatorLabel = "configuration coordinator"
+)
+
+// member line of show cluster instance - "1)  0  10.0.0.1*  UP  ACTIVE  ACTIVE(Configuration Coordinator)"
+var clusterMemberRegex = regexp.MustCompile(`(?m)^\s*\d+\)\s+(\d+)\s+([0-9a-fA-F.:]+)(\*?)\s+(\S+)\s+(\S+)\s+(.*)$`)
+
+type clusterNode struct {
+	id        string
+	password  string
+	mgmtPort  string
+	nodeID    int
+	state     string
+	backplane string
+}
+
+type blxCluster struct {
+	clusterID int
+	clusterIP string
+	backplane string
+	nodes     []clusterNode
+}
+
+type clusterMember struct {
+	nodeID      string
+	ip          string
+	health      string
+	adminState  string
+	operState   string
+	coordinator bool
+}
+
+func getClusterNodeInfo(list []interface{}) []clusterNode {
+	nodes := make([]clusterNode, 0, len(list))
+	for _, item := range list {
+		m := item.(map[string]interface{})
+		nodes = append(nodes, clusterNode{
+			id:        m["id"].(string),
+			password:  m["password"].(string),
+			mgmtPort:  m["mgmt_ssh_port"].(string),
+			nodeID:    m["node_id"].(int),
+			state:     m["state"].(string),
+			backplane: m["backplane"].(string),
+		})
+	}
+	return nodes
+}
+
+func validateCluster(c blxCluster) error {
+	if len(c.nodes) == 0 {
+		return fmt.Errorf("At least one node needed for cluster")
+	}
+	ids := make(map[string]bool)
+	nodeIDs := make(map[int]bool)
+	for _, node := range c.nodes {
+		if ids[node.id] {
+			return fmt.Errorf("BLX %s added more than once to the cluster", node.id)
+		}
+		if nodeIDs[node.nodeID] {
+			return fmt.Errorf("Cluster node id %d used more than once", node.nodeID)
+		}
+		if node.backplane == "" && c.backplane == "" {
+			return fmt.Errorf("No backplane interface set for cluster node %s", node.id)
+		}
+		ids[node.id] = true
+		nodeIDs[node.nodeID] = true
+	}
+	return nil
+}
+
+// backplane interface in cluster notation, <node id>/<interface>
+func clusterBackplane(c blxCluster, node clusterNode) string {
+	backplane := node.backplane
+	if backplane == "" {
+		backplane = c.backplane
+	}
+	return fmt.Sprintf("%d/%s", node.nodeID, backplane)
+}
+
+func genClusterNodeCmd(c blxCluster, node clusterNode) string {
+	return fmt.Sprintf("add cluster node %d %s -state %s -backplane %s", node.nodeID, node.id, node.state, clusterBackplane(c, node))
+}
+
+func parseClusterInstance(out string) (string, []clusterMember) {
+	status := parseNSShow(out)["cluster status"]
+	var members []clusterMember
+	for _, match := range clusterMemberRegex.FindAllStringSubmatch(out, -1) {
+		members = append(members, clusterMember{
+			nodeID:      match[1],
+			ip:          match[2],
+			health:      match[4],
+			adminState:  match[5],
+			operState:   strings.TrimSpace(match[6]),
+			coordinator: match[3] == "*" || strings.Contains(strings.ToLower(match[6]), clusterCoordinatorLabel),
+		})
+	}
+	return status, members
+}
+
+// warm reboot of a BLX, needed after enabling or joining a cluster. A
+// joined node takes the password of the cluster, the node is not connected
+// to again, the cluster is checked through the CLIP instead
+func clusterRebootNode(client Transport, node clusterNode) {
+	log.Printf("[INFO]  citrixblx-provider: Warm reboot of cluster node %s", node.id)
+	runNSCmd(client, "reboot -warm")
+	client.Close()
+	sleep(clusterRebootWait)
+}
+
+func clusterConnect(c blxCluster) (Transport, error) {
+	first := c.nodes[0]
+	return nsConnectAddr(c.clusterIP, first.mgmtPort, first.password)
+}
+
+// create the cluster instance and CLIP on the first node
+func createClusterInstance(c blxCluster) error {
+	first := c.nodes[0]
+	client, err := nsConnectAddr(first.id, first.mgmtPort, first.password)
+	if err != nil {
+		return err
+	}
+	cmds := []string{
+		fmt.Sprintf("add cluster instance %d", c.clusterID),
+		genClusterNodeCmd(c, first),
+		fmt.Sprintf("add ns ip %s 255.255.255.255 -type CLIP", c.clusterIP),
+		fmt.Sprintf("enable cluster instance %d", c.clusterID),
+		"save ns config",
+	}
+	err = runNSCmds(client, cmds)
+	if err != nil {
+		client.Close()
+		return fmt.Errorf("Error creating cluster instance on %s.\r\n%v", first.id, err)
+	}
+	clusterRebootNode(client, first)
+	log.Printf("[INFO]  citrixblx-provider: Cluster instance %d created on %s", c.clusterID, first.id)
+	return nil
+}
+
+// add the node through the CLIP, then join it to the cluster
+func addClusterNode(c blxCluster, clip Transport, node clusterNode) error {
+	err := runNSCmds(clip, []string{genClusterNodeCmd(c, node), "save ns config"})
+	if err != nil {
+		return fmt.Errorf("Error adding cluster node %s.\r\n%v", node.id, err)
+	}
+
+	client, err := nsConnectAddr(node.id, node.mgmtPort, node.password)
+	if err != nil {
+		return err
+	}
+	err = runNSCmds(client, []string{
+		fmt.Sprintf("join cluster -clip %s -password %s", c.clusterIP, c.nodes[0].password),
+		"save ns config",
+	})
+	if err != nil {
+		client.Close()
+		return fmt.Errorf("Error joining %s to cluster %s.\r\n%v", node.id, c.clusterIP, err)
+	}
+	clusterRebootNode(client, node)
+	log.Printf("[INFO]  citrixblx-provider: Node %s joined cluster %s", node.id, c.clusterIP)
+	return nil
+}
+
+func removeClusterNode(clip Transport, nodeID int) error {
+	err := runNSCmds(clip, []string{fmt.Sprintf("rm cluster node %d", nodeID), "save ns config"})
+	if err != nil {
+		return fmt.Errorf("Error removing cluster node %d.\r\n%v", nodeID, err)
+	}
+	log.Printf("[INFO]  citrixblx-provider: Cluster node %d removed", nodeID)
+	return nil
+}
+
+func setClusterNodeState(clip Transport, node clusterNode) error {
+	err := runNSCmds(clip, []string{fmt.Sprintf("set cluster node %d -state %s", node.nodeID, node.state), "save ns config"})
+	if err != nil {
+		return fmt.Errorf("Error setting state of cluster node %s.\r\n%v", node.id, err)
+	}
+	return nil
+}
+
+func clusterInstanceState(c blxCluster, clip Transport) (string, []clusterMember, error) {
+	out, err := runNSCmd(clip, fmt.Sprintf("show cluster instance %d", c.clusterID))
+	if err != nil {
+		if strings.Contains(strings.ToLower(err.Error()), "does not exist") {
+			return "", nil, nil
+		}
+		return "", nil, err
+	}
+	status, members := parseClusterInstance(out)
+	return status, members, nil
+}
+
+// operational when the cluster is up and every node is a healthy member
+func clusterOperational(c blxCluster, status string, members []clusterMember) bool {
+	status = strings.ToLower(status)
+	if !strings.Contains(status, "enabled(operational)") || !strings.HasSuffix(status, "up") {
+		return false
+	}
+	healthy := make(map[string]bool)
+	for _, member := range members {
+		if strings.EqualFold(member.health, "UP") {
+			healthy[member.ip] = true
+		}
+	}
+	for _, node := range c.nodes {
+		if !healthy[node.id] {
+			return false
+		}
+	}
+	return true
+}
+
+func clusterCoordinator(members []clusterMember) string {
+	for _, member := range members {
+		if member.coordinator {
+			return member.ip
+		}
+	}
+	return ""
+}
+
+func waitClusterOperational(c blxCluster) (string, error) {
+	for start := time.Now(); time.Since(start) < clusterOperationalWait; sleep(10 * time.Second) {
+		clip, err := clusterConnect(c)
+		if err != nil {
+			log.Printf("[WARN]  citrixblx-provider: Unable to connect to cluster %s, waiting. %v", c.clusterIP, err)
+			continue
+		}
+		status, members, err := clusterInstanceState(c, clip)
+		clip.Close()
+		if err != nil {
+			return "", err
+		}
+		if clusterOperational(c, status, members) {
+			log.Printf("[INFO]  citrixblx-provider: Cluster %s operational SUCCESS", c.clusterIP)
+			return clusterCoordinator(members), nil
+		}
+		log.Printf("[DEBUG]  citrixblx-provider: Waiting for cluster %s, status %s", c.clusterIP, status)
+	}
+	return "", fmt.Errorf("Cluster %s not operational within %v", c.clusterIP, clusterOperationalWait)
+}
+
+func createCluster(c blxCluster) error {
+	err := createClusterInstance(c)
+	if err != nil {
+		return err
+	}
+	// nodes are added through the CLIP once the first node is back as
+	// the only member
+	instance := c
+	instance.nodes = c.nodes[:1]
+	_, err = waitClusterOperational(instance)
+	if err != nil {
+		return err
+	}
+	clip, err := clusterConnect(c)
+	if err != nil {
+		return err
+	}
+	defer clip.Close()
+	for _, node := range c.nodes[1:] {
+		err = addClusterNode(c, clip, node)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// bring cluster membership in line with the node list, the first node
+// holds the cluster instance and cannot be removed
+func updateCluster(old blxCluster, c blxCluster) error {
+	if old.nodes[0].id != c.nodes[0].id {
+		return fmt.Errorf("First cluster node %s cannot be changed, recreate the cluster", old.nodes[0].id)
+	}
+	clip, err := clusterConnect(c)
+	if err != nil {
+		return err
+	}
+	defer clip.Close()
+
+	current := make(map[string]clusterNode)
+	for _, node := range c.nodes {
+		current[node.id] = node
+	}
+	previous := make(map[string]clusterNode)
+	for _, node := range old.nodes {
+		previous[node.id] = node
+		if _, ok := current[node.id]; !ok {
+			err = removeClusterNode(clip, node.nodeID)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, node := range c.nodes {
+		prev, ok := previous[node.id]
+		switch {
+		case !ok:
+			err = addClusterNode(c, clip, node)
+		case prev.nodeID != node.nodeID || clusterBackplane(old, prev) != clusterBackplane(c, node):
+			err = fmt.Errorf("Node id and backplane of cluster node %s cannot be changed", node.id)
+		case prev.state != node.state:
+			err = setClusterNodeState(clip, node)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func destroyCluster(c blxCluster) error {
+	clip, err := clusterConnect(c)
+	if err != nil {
+		return err
+	}
+	for _, node := range c.nodes[1:] {
+		err = removeClusterNode(clip, node.nodeID)
+		if err != nil {
+			clip.Close()
+			return err
+		}
+	}
+	clip.Close()
+
+	first := c.nodes[0]
+	client, err := nsConnectAddr(first.id, first.mgmtPort, first.password)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	err = runNSCmds(client, []string{fmt.Sprintf("rm cluster instance %d", c.clusterID), "save ns config"})
+	if err != nil {
+		return fmt.Errorf("Error removing cluster instance %d from %s.\r\n%v", c.clusterID, first.id, err)
+	}
+	log.Printf("[INFO]  citrixblx-provider: Cluster %s removed", c.clusterIP)
+	return nil
+}
@@ -0,0 +1,158 @@
+package citrixblx
+
+import (
+	"fmt"
+	"log"
+	"math/big"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// parse a core list such as "2,3,8-11"
+func parseCPUList(str string) ([]int, error) {
+	var cpus []int
+	for _, part := range strings.Split(str, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		bounds := strings.SplitN(part, "-", 2)
+		start, err := strconv.Atoi(strings.TrimSpace(bounds[0]))
+		if err != nil {
+			return nil, fmt.Errorf("invalid core list %s", str)
+		}
+		end := start
+		if len(bounds) == 2 {
+			end, err = strconv.Atoi(strings.TrimSpace(bounds[1]))
+			if err != nil || end < start {
+				return nil, fmt.Errorf("invalid core list %s", str)
+			}
+		}
+		for i := start; i <= end; i++ {
+			cpus = append(cpus, i)
+		}
+	}
+	return cpus, nil
+}
+
+func cpuMask(cpus []int) string {
+	mask := new(big.Int)
+	for _, cpu := range cpus {
+		mask.SetBit(mask, cpu, 1)
+	}
+	return fmt.Sprintf("0x%s", mask.Text(16))
+}
+
+// NUMA nodes the BLX interfaces are attached to
+func interfaceNUMANodes(facts hostFacts, interfaces string) map[int]bool {
+	nodes := make(map[int]bool)
+	for _, intf := range strings.Fields(interfaces) {
+		for _, nic := range facts.nics {
+			if (nic.name == intf || nic.pciAddress == intf) && nic.numaNode >= 0 {
+				nodes[nic.numaNode] = true
+			}
+		}
+	}
+	return nodes
+}
+
+// pick the worker cores from a core count or an explicit core list,
+// cores on the interface NUMA nodes are preferred. An explicit list
+// breaking the skip flags is rejected rather than changed
+func selectWorkerCPUs(facts hostFacts, spec string, interfaces string, skipCore0 bool, skipSiblings bool) ([]int, error) {
+	present := make(map[int]hostCPU)
+	for _, cpu := range facts.cpus {
+		present[cpu.id] = cpu
+	}
+
+	type coreID struct{ socket, core int }
+	core0 := coreID{present[0].socket, present[0].core}
+
+	if strings.ContainsAny(spec, ",-") {
+		cpus, err := parseCPUList(spec)
+		if err != nil {
+			return nil, err
+		}
+		usedCores := make(map[coreID]int)
+		for _, id := range cpus {
+			cpu, ok := present[id]
+			if !ok {
+				return nil, fmt.Errorf("core %d in worker_cpus is not present on host", id)
+			}
+			core := coreID{cpu.socket, cpu.core}
+			if skipCore0 && (id == 0 || (skipSiblings && core == core0)) {
+				return nil, fmt.Errorf("core %d in worker_cpus is core 0 or its sibling, worker_cpus_skip_core0 is set", id)
+			}
+			if sibling, ok := usedCores[core]; ok && skipSiblings {
+				return nil, fmt.Errorf("cores %d and %d in worker_cpus are hyperthread siblings, worker_cpus_skip_siblings is set", sibling, id)
+			}
+			usedCores[core] = id
+		}
+		return cpus, nil
+	}
+
+	count, err := strconv.Atoi(strings.TrimSpace(spec))
+	if err != nil || count <= 0 {
+		return nil, fmt.Errorf("worker_cpus must be a core count or a core list, got %s", spec)
+	}
+
+	nodes := interfaceNUMANodes(facts, interfaces)
+	candidates := make([]hostCPU, len(facts.cpus))
+	copy(candidates, facts.cpus)
+	sort.SliceStable(candidates, func(i, j int) bool {
+		if nodes[candidates[i].numaNode] != nodes[candidates[j].numaNode] {
+			return nodes[candidates[i].numaNode]
+		}
+		return candidates[i].id < candidates[j].id
+	})
+
+	usedCores := make(map[coreID]bool)
+
+	var cpus []int
+	for _, cpu := range candidates {
+		if len(cpus) == count {
+			break
+		}
+		id := coreID{cpu.socket, cpu.core}
+		if skipCore0 && (cpu.id == 0 || (skipSiblings && id == core0)) {
+			continue
+		}
+		if skipSiblings && usedCores[id] {
+			continue
+		}
+		usedCores[id] = true
+		cpus = append(cpus, cpu.id)
+	}
+	if len(cpus) < count {
+		return nil, fmt.Errorf("worker_cpus needs %d cores, only %d available on host", count, len(cpus))
+	}
+	sort.Ints(cpus)
+	return cpus, nil
+}
+
+// resolve worker_cpus into the worker-processes core mask of blx.conf
+func updateWorkerCPUs(b *blx) error {
+	if b.config["worker_cpus"] == "" {
+		return nil
+	}
+
+	facts, err := getHostFacts(b)
+	if err != nil {
+		return err
+	}
+	cpus, err := selectWorkerCPUs(facts, b.config["worker_cpus"], b.config["interfaces"],
+		isTrue(b.config["worker_cpus_skip_core0"]), isTrue(b.config["worker_cpus_skip_siblings"]))
+	if err != nil {
+		return err
+	}
+
+	b.workerCPUMask = cpuMask(cpus)
+	b.config["worker_processes"] = fmt.Sprintf("-c %s", b.workerCPUMask)
+	log.Printf("[INFO]  citrixblx-provider: Worker cores %v selected for BLX %s, mask %s", cpus, b.id, b.workerCPUMask)
+	return nil
+}
+
+func isTrue(str string) bool {
+	return str == "yes" || str == "true" || str == "1"
+}
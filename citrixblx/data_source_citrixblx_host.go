@@ -0,0 +1,204 @@
+package citrixblx
+
+import (
+	"github.com/hashicorp/terraform-plugin-sdk/helper/schema"
+	"log"
+	"sort"
+)
+
+func dataSourceCitrixBLXHost() *schema.Resource {
+	return &schema.Resource{
+		Read: dataSourceBLXHostRead,
+
+		Schema: map[string]*schema.Schema{
+			"host": hostSchema(),
+			"distro": {
+				Type:     schema.TypeString,
+				Computed: true,
+			},
+			"distro_version": {
+				Type:     schema.TypeString,
+				Computed: true,
+			},
+			"kernel": {
+				Type:     schema.TypeString,
+				Computed: true,
+			},
+			"cpu_count": {
+				Type:     schema.TypeInt,
+				Computed: true,
+			},
+			"cpus": {
+				Type:     schema.TypeList,
+				Computed: true,
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"id": {
+							Type:     schema.TypeInt,
+							Computed: true,
+						},
+						"core": {
+							Type:     schema.TypeInt,
+							Computed: true,
+						},
+						"socket": {
+							Type:     schema.TypeInt,
+							Computed: true,
+						},
+						"numa_node": {
+							Type:     schema.TypeInt,
+							Computed: true,
+						},
+					},
+				},
+			},
+			"numa_nodes": {
+				Type:     schema.TypeList,
+				Computed: true,
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"id": {
+							Type:     schema.TypeInt,
+							Computed: true,
+						},
+						"cpus": {
+							Type:     schema.TypeList,
+							Computed: true,
+							Elem: &schema.Schema{
+								Type: schema.TypeInt,
+							},
+						},
+					},
+				},
+			},
+			"memory_total": {
+				Type:     schema.TypeInt,
+				Computed: true,
+			},
+			"hugepage_size": {
+				Type:     schema.TypeInt,
+				Computed: true,
+			},
+			"hugepages_total": {
+				Type:     schema.TypeInt,
+				Computed: true,
+			},
+			"hugepages_free": {
+				Type:     schema.TypeInt,
+				Computed: true,
+			},
+			"nics": {
+				Type:     schema.TypeList,
+				Computed: true,
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"name": {
+							Type:     schema.TypeString,
+							Computed: true,
+						},
+						"mac": {
+							Type:     schema.TypeString,
+							Computed: true,
+						},
+						"pci_address": {
+							Type:     schema.TypeString,
+							Computed: true,
+						},
+						"driver": {
+							Type:     schema.TypeString,
+							Computed: true,
+						},
+						"link_state": {
+							Type:     schema.TypeString,
+							Computed: true,
+						},
+						"numa_node": {
+							Type:     schema.TypeInt,
+							Computed: true,
+						},
+						"sriov_capable": {
+							Type:     schema.TypeBool,
+							Computed: true,
+						},
+						"sriov_total_vfs": {
+							Type:     schema.TypeInt,
+							Computed: true,
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+func dataSourceBLXHostRead(d *schema.ResourceData, m interface{}) error {
+	log.Printf("[DEBUG]  citrixblx-provider: In BLX Host Data Source Read Function")
+
+	host := getHostInfo(d.Get("host").(map[string]interface{}))
+	hostSession, err := hostConnect(host)
+	if err != nil {
+		return err
+	}
+
+	b := blx{
+		host:        host,
+		hostSession: hostSession,
+	}
+	facts, err := getHostFacts(&b)
+	if err != nil {
+		return err
+	}
+
+	cpus := make([]map[string]interface{}, 0, len(facts.cpus))
+	for _, cpu := range facts.cpus {
+		cpus = append(cpus, map[string]interface{}{
+			"id":        cpu.id,
+			"core":      cpu.core,
+			"socket":    cpu.socket,
+			"numa_node": cpu.numaNode,
+		})
+	}
+
+	topology := numaTopology(facts.cpus)
+	nodeIDs := make([]int, 0, len(topology))
+	for id := range topology {
+		nodeIDs = append(nodeIDs, id)
+	}
+	sort.Ints(nodeIDs)
+	numaNodes := make([]map[string]interface{}, 0, len(nodeIDs))
+	for _, id := range nodeIDs {
+		numaNodes = append(numaNodes, map[string]interface{}{
+			"id":   id,
+			"cpus": topology[id],
+		})
+	}
+
+	nics := make([]map[string]interface{}, 0, len(facts.nics))
+	for _, nic := range facts.nics {
+		nics = append(nics, map[string]interface{}{
+			"name":            nic.name,
+			"mac":             nic.mac,
+			"pci_address":     nic.pciAddress,
+			"driver":          nic.driver,
+			"link_state":      nic.linkState,
+			"numa_node":       nic.numaNode,
+			"sriov_capable":   nic.sriovTotalVFs > 0,
+			"sriov_total_vfs": nic.sriovTotalVFs,
+		})
+	}
+
+	d.SetId(host["ipaddress"])
+	d.Set("distro", facts.distro)
+	d.Set("distro_version", facts.distroVersion)
+	d.Set("kernel", facts.kernel)
+	d.Set("cpu_count", len(facts.cpus))
+	d.Set("cpus", cpus)
+	d.Set("numa_nodes", numaNodes)
+	d.Set("memory_total", facts.memTotal/1024)
+	d.Set("hugepage_size", facts.hugepageSize)
+	d.Set("hugepages_total", facts.hugepagesTotal)
+	d.Set("hugepages_free", facts.hugepagesFree)
+	d.Set("nics", nics)
+
+	return nil
+}
@@ -0,0 +1,263 @@
+package citrixblx
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	pkgDNF    = "dnf"
+	pkgYUM    = "yum"
+	pkgAPT    = "apt"
+	pkgZypper = "zypper"
+
+	osReleaseFile = "/etc/os-release"
+
+	defaultPkgLockTimeout = 600
+)
+
+// output of package managers when another process holds their lock
+var pkgLockErrors = []string{
+	"Could not get lock",
+	"Unable to acquire the dpkg frontend lock",
+	"Unable to lock the administration directory",
+	"Existing lock /var/run/yum.pid",
+	"another app is currently holding the yum lock",
+	"Failed to obtain the transaction lock",
+	"System management is locked by the application",
+}
+
+// pkgManager builds the package commands for one host distribution,
+// returned commands are run through execSudoCmdHost
+type pkgManager interface {
+	name() string
+	dist() string
+	install(pkgs string) string
+	installLocal(pkgs string) string
+	downgrade(pkgs string) string
+	reinstall(pkgs string) string
+	remove(pkg string) string
+	search(pkg string) string
+	queryVersion(pkg string) string
+	kernelHeaders() string
+	lockHolder() string
+}
+
+type rpmManager struct {
+	cmd string
+}
+
+func (p rpmManager) name() string { return p.cmd }
+func (p rpmManager) dist() string { return distRPM }
+func (p rpmManager) install(pkgs string) string {
+	return fmt.Sprintf("%s install -y %s", p.cmd, pkgs)
+}
+func (p rpmManager) installLocal(pkgs string) string {
+	return fmt.Sprintf("%s install -y --disablerepo=\\\"*\\\" %s", p.cmd, pkgs)
+}
+func (p rpmManager) downgrade(pkgs string) string {
+	return fmt.Sprintf("%s downgrade -y %s", p.cmd, pkgs)
+}
+func (p rpmManager) reinstall(pkgs string) string {
+	return fmt.Sprintf("%s reinstall -y %s", p.cmd, pkgs)
+}
+func (p rpmManager) remove(pkg string) string {
+	return fmt.Sprintf("%s remove -y %s", p.cmd, pkg)
+}
+func (p rpmManager) search(pkg string) string {
+	return fmt.Sprintf("%s search %s", p.cmd, pkg)
+}
+func (p rpmManager) queryVersion(pkg string) string {
+	return fmt.Sprintf("rpm -q --qf '%%{VERSION}-%%{RELEASE}' %s", pkg)
+}
+func (p rpmManager) kernelHeaders() string {
+	return fmt.Sprintf("%s install -y kernel-devel-\\$(uname -r)", p.cmd)
+}
+func (p rpmManager) lockHolder() string {
+	return "cat /var/run/yum.pid /var/cache/dnf/*.pid 2>/dev/null ; ps -o pid,etime,args -C yum,dnf,packagekitd"
+}
+
+type aptManager struct{}
+
+func (p aptManager) name() string { return pkgAPT }
+func (p aptManager) dist() string { return distDEB }
+func (p aptManager) install(pkgs string) string {
+	return fmt.Sprintf("apt install -y -o Dpkg::Options::=\\\"--force-confold\\\" --allow-downgrades %s", pkgs)
+}
+func (p aptManager) installLocal(pkgs string) string {
+	return fmt.Sprintf("apt install -y --no-download -o Dpkg::Options::=\\\"--force-confold\\\" --allow-downgrades %s", pkgs)
+}
+func (p aptManager) downgrade(pkgs string) string {
+	return p.install(pkgs)
+}
+func (p aptManager) reinstall(pkgs string) string {
+	return fmt.Sprintf("apt install -y --reinstall -o Dpkg::Options::=\\\"--force-confold\\\" %s", pkgs)
+}
+func (p aptManager) remove(pkg string) string {
+	return fmt.Sprintf("apt-get -y purge %s", pkg)
+}
+func (p aptManager) search(pkg string) string {
+	return fmt.Sprintf("apt-cache search %s", pkg)
+}
+func (p aptManager) queryVersion(pkg string) string {
+	return fmt.Sprintf("dpkg-query -W -f='\\${Version}' %s", pkg)
+}
+func (p aptManager) kernelHeaders() string {
+	return "apt install -y linux-headers-\\$(uname -r)"
+}
+func (p aptManager) lockHolder() string {
+	return "fuser -v /var/lib/dpkg/lock-frontend /var/lib/dpkg/lock /var/lib/apt/lists/lock 2>&1 ; ps -o pid,etime,args -C apt,apt-get,dpkg,unattended-upgr"
+}
+
+type zypperManager struct{}
+
+func (p zypperManager) name() string { return pkgZypper }
+func (p zypperManager) dist() string { return distRPM }
+func (p zypperManager) install(pkgs string) string {
+	return fmt.Sprintf("zypper --non-interactive install --allow-unsigned-rpm %s", pkgs)
+}
+func (p zypperManager) installLocal(pkgs string) string {
+	return fmt.Sprintf("zypper --non-interactive --disable-repositories install --allow-unsigned-rpm %s", pkgs)
+}
+func (p zypperManager) downgrade(pkgs string) string {
+	return fmt.Sprintf("zypper --non-interactive install --oldpackage --allow-unsigned-rpm %s", pkgs)
+}
+func (p zypperManager) reinstall(pkgs string) string {
+	return fmt.Sprintf("zypper --non-interactive install --force --allow-unsigned-rpm %s", pkgs)
+}
+func (p zypperManager) remove(pkg string) string {
+	return fmt.Sprintf("zypper --non-interactive remove %s", pkg)
+}
+func (p zypperManager) search(pkg string) string {
+	return fmt.Sprintf("zypper --non-interactive search %s", pkg)
+}
+func (p zypperManager) queryVersion(pkg string) string {
+	return fmt.Sprintf("rpm -q --qf '%%{VERSION}-%%{RELEASE}' %s", pkg)
+}
+func (p zypperManager) kernelHeaders() string {
+	return "zypper --non-interactive install kernel-default-devel"
+}
+func (p zypperManager) lockHolder() string {
+	return "cat /run/zypp.pid 2>/dev/null ; ps -o pid,etime,args -C zypper,packagekitd"
+}
+
+func parseOSRelease(out string) map[string]string {
+	osRelease := make(map[string]string)
+	for _, line := range strings.Split(out, "\n") {
+		kv := strings.SplitN(strings.TrimSpace(line), "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		osRelease[kv[0]] = strings.Trim(kv[1], "\"'")
+	}
+	return osRelease
+}
+
+// pick the package manager for an os-release ID, ID_LIKE and VERSION_ID.
+// VERSION_ID is the version of ID, a distribution only like RHEL with a
+// version outside of the RHEL gate is rejected, such as Amazon Linux 2
+func selectPkgManager(id string, idLike string, version string) (pkgManager, error) {
+	family := strings.Fields(strings.ToLower(idLike))
+	family = append([]string{strings.ToLower(id)}, family...)
+
+	unsupported := fmt.Errorf("Unsupported OS distribution %s %s. Supported distributions are RHEL/CentOS 7-9, Ubuntu, Debian and SLES", id, version)
+	major, _ := strconv.Atoi(strings.SplitN(version, ".", 2)[0])
+	for i, f := range family {
+		switch f {
+		case "rhel", "centos", "rocky", "almalinux", "ol":
+			if major >= 8 {
+				return rpmManager{cmd: pkgDNF}, nil
+			}
+			if major == 7 {
+				return rpmManager{cmd: pkgYUM}, nil
+			}
+			return nil, unsupported
+		case "fedora":
+			// versions of fedora based distributions are not fedora versions
+			if i == 0 {
+				return rpmManager{cmd: pkgDNF}, nil
+			}
+		case "ubuntu", "debian":
+			return aptManager{}, nil
+		case "sles", "sled", "suse", "opensuse", "opensuse-leap":
+			return zypperManager{}, nil
+		}
+	}
+	return nil, unsupported
+}
+
+func updateDistro(b *blx) error {
+	out, err := execCmdHost(b, fmt.Sprintf("cat %s", osReleaseFile))
+	if err != nil {
+		log.Printf("[ERROR]  citrixblx-provider: Error occured while reading %s", osReleaseFile)
+		return fmt.Errorf("Failed to get distribution.\r\n%v", err)
+	}
+
+	osRelease := parseOSRelease(out)
+	pkg, err := selectPkgManager(osRelease["ID"], osRelease["ID_LIKE"], osRelease["VERSION_ID"])
+	if err != nil {
+		log.Printf("[ERROR]  citrixblx-provider: %v", err)
+		return err
+	}
+
+	b.distro = osRelease["ID"]
+	b.distroVersion = osRelease["VERSION_ID"]
+	b.pkg = pkg
+	b.dist = pkg.dist()
+	log.Printf("[DEBUG]  citrixblx-provider: Host distribution %s %s, using %s", b.distro, b.distroVersion, pkg.name())
+	return nil
+}
+
+// package commands need a supported distribution, the other steps such
+// as destroy work on any host
+func requirePkgManager(b *blx) error {
+	if b.pkg != nil {
+		return nil
+	}
+	if b.pkgErr != nil {
+		return b.pkgErr
+	}
+	return fmt.Errorf("Distribution of host %s unknown", b.host["ipaddress"])
+}
+
+func isPkgLockError(out string) bool {
+	for _, str := range pkgLockErrors {
+		if strings.Contains(out, str) {
+			return true
+		}
+	}
+	return false
+}
+
+// run a package manager command, waiting with backoff while
+// another process holds the package manager lock
+func execPkgCmdHost(b *blx, cmd string) (string, error) {
+	timeout := b.pkgLockTimeout
+	if timeout == 0 {
+		timeout = defaultPkgLockTimeout * time.Second
+	}
+	var waited time.Duration
+	wait := 5 * time.Second
+
+	for {
+		out, err := execSudoCmdHost(b, cmd)
+		if err == nil || !isPkgLockError(fmt.Sprintf("%s\n%v", out, err)) {
+			return out, err
+		}
+		if waited+wait > timeout {
+			return out, fmt.Errorf("%s lock still held after waiting %v.\r\n%v", b.pkg.name(), timeout, err)
+		}
+
+		holder, _ := execSudoCmdHost(b, b.pkg.lockHolder())
+		log.Printf("[WARN]  citrixblx-provider: %s lock is held, retrying in %v. Lock holder -\n%s", b.pkg.name(), wait, holder)
+		sleep(wait)
+		waited += wait
+		wait *= 2
+		if wait > time.Minute {
+			wait = time.Minute
+		}
+	}
+}
@@ -0,0 +1,184 @@
+package citrixblx
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const defaultDrainTimeout = 300
+
+// list item of show lb/cs vserver - "1)	vs1 (10.1.1.1:80) - HTTP	Type: ADDRESS"
+var vserverNameRegex = regexp.MustCompile(`^\s*(\S+)\s+\(`)
+
+type drainConfig struct {
+	vservers  []string
+	threshold int
+	timeout   time.Duration
+}
+
+// vserver disabled for a drain, kind is lb or cs
+type drainedVserver struct {
+	kind string
+	name string
+}
+
+func getDrainInfo(list []interface{}) *drainConfig {
+	if len(list) == 0 || list[0] == nil {
+		return nil
+	}
+	m := list[0].(map[string]interface{})
+	drain := &drainConfig{
+		threshold: m["threshold"].(int),
+		timeout:   time.Duration(m["timeout"].(int)) * time.Second,
+	}
+	for _, i := range m["vservers"].([]interface{}) {
+		drain.vservers = append(drain.vservers, i.(string))
+	}
+	return drain
+}
+
+// enabled vservers of a kind, by name
+func listVservers(client Transport, kind string) (map[string]bool, error) {
+	out, err := runNSCmd(client, fmt.Sprintf("show %s vserver", kind))
+	if err != nil {
+		return nil, err
+	}
+	vservers := make(map[string]bool)
+	for _, item := range splitNSList(out) {
+		match := vserverNameRegex.FindStringSubmatch(item)
+		if match == nil {
+			continue
+		}
+		vservers[match[1]] = !strings.EqualFold(parseNSShow(item)["state"], "OUT OF SERVICE")
+	}
+	return vservers, nil
+}
+
+// vservers to disable for the drain, either the named ones or all
+func drainTargets(client Transport, drain *drainConfig) ([]drainedVserver, error) {
+	var targets []drainedVserver
+	found := make(map[string]bool)
+	for _, kind := range []string{"lb", "cs"} {
+		vservers, err := listVservers(client, kind)
+		if err != nil {
+			return nil, err
+		}
+		for name, enabled := range vservers {
+			found[name] = true
+			if !enabled {
+				continue
+			}
+			if len(drain.vservers) == 0 || containsString(drain.vservers, name) {
+				targets = append(targets, drainedVserver{kind, name})
+			}
+		}
+	}
+	for _, name := range drain.vservers {
+		if !found[name] {
+			log.Printf("[WARN]  citrixblx-provider: Drain vserver %s not found", name)
+		}
+	}
+	return targets, nil
+}
+
+func containsString(list []string, str string) bool {
+	for _, i := range list {
+		if i == str {
+			return true
+		}
+	}
+	return false
+}
+
+func vserverConnections(client Transport, vserver drainedVserver) (int, error) {
+	out, err := runNSCmd(client, fmt.Sprintf("stat %s vserver %s", vserver.kind, vserver.name))
+	if err != nil {
+		return 0, err
+	}
+	for _, line := range strings.Split(out, "\n") {
+		if strings.HasPrefix(strings.TrimSpace(line), "Current client connections") {
+			fields := strings.Fields(line)
+			return strconv.Atoi(fields[len(fields)-1])
+		}
+	}
+	return 0, nil
+}
+
+// disable the drain vservers and wait for their client connections to
+// fall to the threshold, a stopped or unreachable BLX is not drained
+func drainBLX(b *blx) error {
+	if b.drain == nil {
+		return nil
+	}
+	conn, err := dialTimeout("tcp", net.JoinHostPort(b.id, nsMgmtPort(b)), 2*time.Second)
+	if err != nil {
+		log.Printf("[DEBUG]  citrixblx-provider: BLX %s not reachable, skipping drain", b.id)
+		return nil
+	}
+	conn.Close()
+
+	// the password may be changing in this update, BLX is then stopped
+	// without a drain like an unreachable one
+	client, err := nsConnect(b)
+	if err != nil {
+		log.Printf("[WARN]  citrixblx-provider: Unable to log in to BLX %s, skipping drain. %v", b.id, err)
+		return nil
+	}
+
+	targets, err := drainTargets(client, b.drain)
+	if err != nil {
+		return fmt.Errorf("Error listing vservers to drain on %s.\r\n%v", b.id, err)
+	}
+	for _, vserver := range targets {
+		_, err = runNSCmd(client, fmt.Sprintf("disable %s vserver %s", vserver.kind, vserver.name))
+		if err != nil {
+			return fmt.Errorf("Error disabling vserver %s for drain.\r\n%v", vserver.name, err)
+		}
+		b.drained = append(b.drained, vserver)
+	}
+	log.Printf("[INFO]  citrixblx-provider: Draining %d vservers on BLX %s", len(b.drained), b.id)
+
+	for waited := time.Duration(0); waited < b.drain.timeout; waited += pause(5 * time.Second) {
+		total := 0
+		for _, vserver := range b.drained {
+			num, err := vserverConnections(client, vserver)
+			if err != nil {
+				return err
+			}
+			total += num
+		}
+		if total <= b.drain.threshold {
+			log.Printf("[INFO]  citrixblx-provider: BLX %s drained, %d client connections left", b.id, total)
+			return nil
+		}
+		log.Printf("[DEBUG]  citrixblx-provider: Draining BLX %s, %d client connections left", b.id, total)
+	}
+	log.Printf("[WARN]  citrixblx-provider: Drain of BLX %s timed out after %v, stopping", b.id, b.drain.timeout)
+	return nil
+}
+
+// re-enable the vservers disabled for a drain
+func undrainBLX(b *blx) error {
+	if len(b.drained) == 0 {
+		return nil
+	}
+	client, err := nsConnect(b)
+	if err != nil {
+		return err
+	}
+
+	for _, vserver := range b.drained {
+		_, err = runNSCmd(client, fmt.Sprintf("enable %s vserver %s", vserver.kind, vserver.name))
+		if err != nil {
+			log.Printf("[WARN]  citrixblx-provider: Unable to enable vserver %s after drain, %v", vserver.name, err)
+		}
+	}
+	log.Printf("[INFO]  citrixblx-provider: Re-enabled %d drained vservers on BLX %s", len(b.drained), b.id)
+	b.drained = nil
+	return nil
+}
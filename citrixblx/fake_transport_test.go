@@ -0,0 +1,163 @@
+package citrixblx
+
+import (
+	"errors"
+	"net"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+// scripted reply to commands containing match, times 0 for every command
+type fakeReply struct {
+	match string
+	out   string
+	err   error
+	times int
+}
+
+// Transport replying to commands from a script. Sudo commands are written
+// to the sudo-cmd file before they run, the fake records and matches the
+// command itself instead of the sudo wrapper
+type fakeTransport struct {
+	replies     []*fakeReply
+	cmds        []string
+	copies      []string
+	copyErr     error
+	sudoCmd     string
+	unreachable bool
+}
+
+var fakeSudoWriteRegex = regexp.MustCompile(`(?s)^echo "(.*)" > \S*/sudo-cmd$`)
+
+const fakeOSRelease = `NAME="Ubuntu"
+VERSION_ID="20.04"
+ID=ubuntu
+ID_LIKE=debian`
+
+// fake of a reachable Ubuntu host with enough space and BLX not running
+func newFakeTransport() *fakeTransport {
+	f := &fakeTransport{}
+	f.on("cd ~/.terraform_blx ; pwd", "/home/user/.terraform_blx")
+	f.on("cat /etc/os-release", fakeOSRelease)
+	f.on("df -Pk", "20000000")
+	f.on("/usr/sbin/nsppe", "0")
+	f.on("ss -Hltn", "0")
+	return f
+}
+
+// reply out to commands containing match, later replies take precedence
+func (f *fakeTransport) on(match string, out string) *fakeTransport {
+	f.replies = append(f.replies, &fakeReply{match: match, out: out})
+	return f
+}
+
+func (f *fakeTransport) fail(match string, out string) *fakeTransport {
+	f.replies = append(f.replies, &fakeReply{match: match, out: out, err: errors.New("exit status 1")})
+	return f
+}
+
+// fail only the next times commands containing match
+func (f *fakeTransport) failTimes(match string, out string, times int) *fakeTransport {
+	f.replies = append(f.replies, &fakeReply{match: match, out: out, err: errors.New("exit status 1"), times: times})
+	return f
+}
+
+func (f *fakeTransport) Run(cmd string) (string, error) {
+	cmd = strings.TrimPrefix(cmd, pathEnvPreStr+" ; ")
+	if m := fakeSudoWriteRegex.FindStringSubmatch(cmd); m != nil {
+		f.sudoCmd = strings.NewReplacer(`\"`, `"`, `\$`, `$`).Replace(m[1])
+		return "", nil
+	}
+	if strings.Contains(cmd, "sudo -S -k") {
+		cmd = f.sudoCmd
+	}
+	f.cmds = append(f.cmds, cmd)
+
+	for i := len(f.replies) - 1; i >= 0; i-- {
+		reply := f.replies[i]
+		if !strings.Contains(cmd, reply.match) {
+			continue
+		}
+		if reply.times > 0 {
+			reply.times--
+			if reply.times == 0 {
+				f.replies = append(f.replies[:i], f.replies[i+1:]...)
+			}
+		}
+		return reply.out, reply.err
+	}
+	return "", nil
+}
+
+func (f *fakeTransport) Copy(source string, dest string) error {
+	if f.copyErr != nil {
+		return f.copyErr
+	}
+	f.copies = append(f.copies, dest)
+	return nil
+}
+
+func (f *fakeTransport) Close() error {
+	return nil
+}
+
+// index of the first command containing str, -1 when not run
+func (f *fakeTransport) ran(str string) int {
+	for i, cmd := range f.cmds {
+		if strings.Contains(cmd, str) {
+			return i
+		}
+	}
+	return -1
+}
+
+// replace the connection and timing hooks for the test, host and NS
+// commands both go to f
+func useFakeTransport(t *testing.T, f *fakeTransport) {
+	oldHost, oldNS, oldDial, oldSleep := hostConnect, nsConnect, dialTimeout, sleep
+	hostConnect = func(hostInfo map[string]string) (Transport, error) {
+		return f, nil
+	}
+	nsConnect = func(b *blx) (Transport, error) {
+		return f, nil
+	}
+	dialTimeout = func(network string, address string, timeout time.Duration) (net.Conn, error) {
+		if f.unreachable {
+			return nil, errors.New("connection refused")
+		}
+		client, server := net.Pipe()
+		server.Close()
+		return client, nil
+	}
+	sleep = func(time.Duration) {}
+	t.Cleanup(func() {
+		hostConnect, nsConnect, dialTimeout, sleep = oldHost, oldNS, oldDial, oldSleep
+	})
+}
+
+// BLX in shared mode on an initialized fake host
+func newTestBLX(t *testing.T, f *fakeTransport) *blx {
+	useFakeTransport(t, f)
+	b := &blx{
+		id:     "10.0.0.10",
+		source: "https://example.com/blx-deb-13.1-37.38.tar.gz",
+		host: map[string]string{
+			"ipaddress": "10.0.0.10",
+			"username":  "user",
+			"password":  "hostpass",
+		},
+		config:      map[string]string{"interfaces": "eth1"},
+		mlx:         map[string]string{},
+		password:    "nsrootpass",
+		hostSession: f,
+	}
+	err := initBLXHost(b)
+	if err != nil {
+		t.Fatalf("initBLXHost: %v", err)
+	}
+	f.cmds = nil
+	f.copies = nil
+	return b
+}
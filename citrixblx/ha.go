@@ -0,0 +1,246 @@
+package citrixblx
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const (
+	haPeerNodeID  = 1
+	haSyncTimeout = 10 * time.Minute
+)
+
+var nsListItemRegex = regexp.MustCompile(`(?m)^\s*\d+\)`)
+
+type haPeer struct {
+	id       string
+	password string
+	mgmtPort string
+	client   Transport
+}
+
+type haPair struct {
+	nodes       []*haPeer
+	rpcPassword string
+	sync        bool
+	propagation bool
+}
+
+// one entry of show ha node, node id 0 is the local node
+type haNodeState struct {
+	id          string
+	ip          string
+	nodeState   string
+	masterState string
+	syncState   string
+}
+
+func getHAPeerInfo(list []interface{}) []*haPeer {
+	peers := make([]*haPeer, 0, len(list))
+	for _, i := range list {
+		m := i.(map[string]interface{})
+		peers = append(peers, &haPeer{
+			id:       m["id"].(string),
+			password: m["password"].(string),
+			mgmtPort: m["mgmt_ssh_port"].(string),
+		})
+	}
+	return peers
+}
+
+// split NS list output such as "1) Node ID: 0 ..." into its items
+func splitNSList(out string) []string {
+	locs := nsListItemRegex.FindAllStringIndex(out, -1)
+	items := make([]string, 0, len(locs))
+	for i, loc := range locs {
+		end := len(out)
+		if i+1 < len(locs) {
+			end = locs[i+1][0]
+		}
+		items = append(items, out[loc[1]:end])
+	}
+	return items
+}
+
+func parseHANodes(out string) []haNodeState {
+	var nodes []haNodeState
+	for _, item := range splitNSList(out) {
+		values := parseNSShow(item)
+		node := haNodeState{
+			id:          values["node id"],
+			nodeState:   values["node state"],
+			masterState: values["master state"],
+			syncState:   values["sync state"],
+		}
+		if fields := strings.Fields(values["ip"]); len(fields) != 0 {
+			node.ip = fields[0]
+		}
+		nodes = append(nodes, node)
+	}
+	return nodes
+}
+
+// once paired the secondary has the nsroot password of the first node,
+// its own password is only used before the pair is created
+func haConnect(pair *haPair) error {
+	first := pair.nodes[0]
+	for _, peer := range pair.nodes {
+		var err error
+		if peer != first && peer.password != first.password {
+			peer.client, err = nsConnectAddr(peer.id, peer.mgmtPort, first.password)
+			if err == nil {
+				continue
+			}
+			log.Printf("[DEBUG]  citrixblx-provider: Login to %s with the password of %s failed, using its own. %v", peer.id, first.id, err)
+		}
+		peer.client, err = nsConnectAddr(peer.id, peer.mgmtPort, peer.password)
+		if err != nil {
+			haClose(pair)
+			return err
+		}
+	}
+	return nil
+}
+
+func haClose(pair *haPair) {
+	for _, peer := range pair.nodes {
+		if peer.client != nil {
+			peer.client.Close()
+			peer.client = nil
+		}
+	}
+}
+
+func haNodeStates(peer *haPeer) ([]haNodeState, error) {
+	out, err := runNSCmd(peer.client, "show ha node")
+	if err != nil {
+		return nil, err
+	}
+	return parseHANodes(out), nil
+}
+
+func nsEnabled(enabled bool) string {
+	if enabled {
+		return "ENABLED"
+	}
+	return "DISABLED"
+}
+
+func genHACmds(pair *haPair, self *haPeer, peer *haPeer) []string {
+	cmds := []string{fmt.Sprintf("add ha node %d %s", haPeerNodeID, peer.id)}
+	if pair.rpcPassword != "" {
+		cmds = append(cmds,
+			fmt.Sprintf("set ns rpcNode %s -password %s -secure YES", self.id, pair.rpcPassword),
+			fmt.Sprintf("set ns rpcNode %s -password %s -secure YES", peer.id, pair.rpcPassword))
+	}
+	cmds = append(cmds,
+		fmt.Sprintf("set ha node -haSync %s -haProp %s", nsEnabled(pair.sync), nsEnabled(pair.propagation)),
+		"save ns config")
+	return cmds
+}
+
+// run NS commands, errors for objects already present or already gone are ignored
+func runNSCmds(client Transport, cmds []string) error {
+	for _, cmd := range cmds {
+		_, err := runNSCmd(client, cmd)
+		if err != nil {
+			msg := strings.ToLower(err.Error())
+			if strings.Contains(msg, "already exists") || strings.Contains(msg, "does not exist") {
+				log.Printf("[DEBUG]  citrixblx-provider: Ignoring error for command - %s", cmd)
+				continue
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// configure the first node before the second so that it becomes primary
+func configureHAPair(pair *haPair) error {
+	for i, self := range pair.nodes {
+		peer := pair.nodes[1-i]
+		err := runNSCmds(self.client, genHACmds(pair, self, peer))
+		if err != nil {
+			return fmt.Errorf("Error configuring HA node on %s.\r\n%v", self.id, err)
+		}
+		log.Printf("[INFO]  citrixblx-provider: HA node %s added on %s", peer.id, self.id)
+	}
+	return nil
+}
+
+// primary of the pair as reported by the nodes, empty when not synced yet
+func haPairPrimary(pair *haPair) (string, error) {
+	primary := ""
+	secondary := ""
+	for _, peer := range pair.nodes {
+		nodes, err := haNodeStates(peer)
+		if err != nil {
+			return "", err
+		}
+		if len(nodes) < 2 {
+			return "", nil
+		}
+		for _, node := range nodes {
+			if !strings.EqualFold(node.nodeState, "UP") {
+				return "", nil
+			}
+		}
+		local := nodes[0]
+		switch strings.ToLower(local.masterState) {
+		case "primary":
+			primary = peer.id
+		case "secondary":
+			if pair.sync && !strings.EqualFold(local.syncState, "SUCCESS") {
+				return "", nil
+			}
+			secondary = peer.id
+		}
+	}
+	if primary == "" || secondary == "" {
+		return "", nil
+	}
+	return primary, nil
+}
+
+func waitHAPair(pair *haPair) (string, error) {
+	for start := time.Now(); time.Since(start) < haSyncTimeout; sleep(10 * time.Second) {
+		primary, err := haPairPrimary(pair)
+		if err != nil {
+			return "", err
+		}
+		if primary != "" {
+			log.Printf("[INFO]  citrixblx-provider: HA pair %s, %s synced, primary %s SUCCESS", pair.nodes[0].id, pair.nodes[1].id, primary)
+			return primary, nil
+		}
+		log.Printf("[DEBUG]  citrixblx-provider: Waiting for HA pair %s, %s to sync", pair.nodes[0].id, pair.nodes[1].id)
+	}
+	return "", fmt.Errorf("HA pair %s, %s not synced within %v", pair.nodes[0].id, pair.nodes[1].id, haSyncTimeout)
+}
+
+// paired when the first node still has its peer node
+func haPaired(pair *haPair) (bool, error) {
+	nodes, err := haNodeStates(pair.nodes[0])
+	if err != nil {
+		return false, err
+	}
+	for _, node := range nodes {
+		if node.ip == pair.nodes[1].id {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func removeHAPair(pair *haPair) error {
+	for _, self := range pair.nodes {
+		err := runNSCmds(self.client, []string{fmt.Sprintf("rm ha node %d", haPeerNodeID), "save ns config"})
+		if err != nil {
+			return fmt.Errorf("Error removing HA node on %s.\r\n%v", self.id, err)
+		}
+		log.Printf("[INFO]  citrixblx-provider: HA node removed on %s", self.id)
+	}
+	return nil
+}
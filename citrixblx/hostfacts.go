@@ -0,0 +1,147 @@
+package citrixblx
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type hostNIC struct {
+	name          string
+	mac           string
+	pciAddress    string
+	driver        string
+	linkState     string
+	numaNode      int
+	sriovTotalVFs int
+}
+
+type hostCPU struct {
+	id       int
+	core     int
+	socket   int
+	numaNode int
+}
+
+type hostFacts struct {
+	kernel         string
+	distro         string
+	distroVersion  string
+	cpus           []hostCPU
+	nics           []hostNIC
+	memTotal       int
+	hugepageSize   int
+	hugepagesTotal int
+	hugepagesFree  int
+}
+
+// one line per NIC - name|mac|pci|driver|operstate|numa_node|sriov_totalvfs
+const nicFactsCmd = `for n in /sys/class/net/* ; do i=$(basename $n) ; [ "$i" = "lo" ] && continue ; ` +
+	`pci="" ; drv="" ; numa="-1" ; vfs="0" ; ` +
+	`if [ -e $n/device ] ; then pci=$(readlink -f $n/device | grep -oE '[0-9a-f]{4}:[0-9a-f]{2}:[0-9a-f]{2}\.[0-7]' | tail -1) ; ` +
+	`[ -e $n/device/driver ] && drv=$(basename $(readlink -f $n/device/driver)) ; ` +
+	`[ -r $n/device/numa_node ] && numa=$(cat $n/device/numa_node) ; ` +
+	`[ -r $n/device/sriov_totalvfs ] && vfs=$(cat $n/device/sriov_totalvfs) ; fi ; ` +
+	`echo "$i|$(cat $n/address 2>/dev/null)|$pci|$drv|$(cat $n/operstate 2>/dev/null)|$numa|$vfs" ; done`
+
+func parseNICFacts(out string) []hostNIC {
+	var nics []hostNIC
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.Split(strings.TrimSpace(line), "|")
+		if len(fields) != 7 {
+			continue
+		}
+		nic := hostNIC{
+			name:       fields[0],
+			mac:        fields[1],
+			pciAddress: fields[2],
+			driver:     fields[3],
+			linkState:  fields[4],
+		}
+		nic.numaNode, _ = strconv.Atoi(fields[5])
+		nic.sriovTotalVFs, _ = strconv.Atoi(fields[6])
+		nics = append(nics, nic)
+	}
+	return nics
+}
+
+// parse the output of lscpu -p=CPU,CORE,SOCKET,NODE
+func parseCPUFacts(out string) []hostCPU {
+	var cpus []hostCPU
+	for _, line := range strings.Split(out, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Split(line, ",")
+		if len(fields) != 4 {
+			continue
+		}
+		var cpu hostCPU
+		var err error
+		if cpu.id, err = strconv.Atoi(fields[0]); err != nil {
+			continue
+		}
+		cpu.core, _ = strconv.Atoi(fields[1])
+		cpu.socket, _ = strconv.Atoi(fields[2])
+		cpu.numaNode, _ = strconv.Atoi(fields[3])
+		cpus = append(cpus, cpu)
+	}
+	return cpus
+}
+
+// CPU ids per NUMA node
+func numaTopology(cpus []hostCPU) map[int][]int {
+	nodes := make(map[int][]int)
+	for _, cpu := range cpus {
+		nodes[cpu.numaNode] = append(nodes[cpu.numaNode], cpu.id)
+	}
+	for _, ids := range nodes {
+		sort.Ints(ids)
+	}
+	return nodes
+}
+
+// collect host facts with read-only commands over the host session
+func getHostFacts(b *blx) (hostFacts, error) {
+	var facts hostFacts
+
+	out, err := execCmdHost(b, "uname -r")
+	if err != nil {
+		return facts, fmt.Errorf("Unable to get kernel version.\r\n%v", err)
+	}
+	facts.kernel = strings.TrimSpace(out)
+
+	out, err = execCmdHost(b, fmt.Sprintf("cat %s", osReleaseFile))
+	if err != nil {
+		return facts, fmt.Errorf("Unable to get distribution.\r\n%v", err)
+	}
+	osRelease := parseOSRelease(out)
+	facts.distro = osRelease["ID"]
+	facts.distroVersion = osRelease["VERSION_ID"]
+
+	out, err = execCmdHost(b, "lscpu -p=CPU,CORE,SOCKET,NODE")
+	if err != nil {
+		return facts, fmt.Errorf("Unable to get CPU topology.\r\n%v", err)
+	}
+	facts.cpus = parseCPUFacts(out)
+
+	out, err = execCmdHost(b, nicFactsCmd)
+	if err != nil {
+		return facts, fmt.Errorf("Unable to get network interfaces.\r\n%v", err)
+	}
+	facts.nics = parseNICFacts(out)
+
+	out, err = execCmdHost(b, "cat /proc/meminfo")
+	if err != nil {
+		return facts, fmt.Errorf("Unable to get memory information.\r\n%v", err)
+	}
+	meminfo := parseMeminfo(out)
+	facts.memTotal = meminfo["MemTotal"]
+	facts.hugepageSize = meminfo["Hugepagesize"]
+	facts.hugepagesTotal = meminfo["HugePages_Total"]
+	facts.hugepagesFree = meminfo["HugePages_Free"]
+
+	return facts, nil
+}
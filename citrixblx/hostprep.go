@@ -0,0 +1,229 @@
+package citrixblx
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+)
+
+const (
+	hugepageSize2M = "2M"
+	hugepageSize1G = "1G"
+
+	hugepageSysctlFile = "/etc/sysctl.d/90-citrixblx-hugepages.conf"
+)
+
+// kernel arguments owned by citrixblx_host_prep
+var hostPrepKernelArgs = []string{
+	"intel_iommu",
+	"amd_iommu",
+	"iommu",
+	"isolcpus",
+	"default_hugepagesz",
+	"hugepagesz",
+	"hugepages",
+}
+
+type hostPrep struct {
+	hugepages    int
+	hugepageSize string
+	iommu        bool
+	isolcpus     string
+	reboot       bool
+}
+
+type hostPrepState struct {
+	cmdline        string
+	hugepages      int
+	rebootRequired bool
+}
+
+func hugepageSizeKB(size string) int {
+	if size == hugepageSize1G {
+		return 1024 * 1024
+	}
+	return 2048
+}
+
+func hugepageSysfs(size string) string {
+	return fmt.Sprintf("/sys/kernel/mm/hugepages/hugepages-%dkB/nr_hugepages", hugepageSizeKB(size))
+}
+
+// kernel arguments needed on the host cmdline for the prep settings
+func hostPrepArgs(b *blx, prep hostPrep) ([]string, error) {
+	var args []string
+	if prep.iommu {
+		out, err := execCmdHost(b, "grep -m1 vendor_id /proc/cpuinfo")
+		if err != nil {
+			return nil, fmt.Errorf("Unable to get CPU vendor.\r\n%v", err)
+		}
+		if strings.Contains(out, "AuthenticAMD") {
+			args = append(args, "amd_iommu=on")
+		} else {
+			args = append(args, "intel_iommu=on")
+		}
+		args = append(args, "iommu=pt")
+	}
+	if prep.isolcpus != "" {
+		args = append(args, fmt.Sprintf("isolcpus=%s", prep.isolcpus))
+	}
+	if prep.hugepages != 0 && prep.hugepageSize == hugepageSize1G {
+		args = append(args, "default_hugepagesz=1G", "hugepagesz=1G", fmt.Sprintf("hugepages=%d", prep.hugepages))
+	}
+	return args, nil
+}
+
+// a reboot is needed until the running kernel has exactly the wanted arguments
+func cmdlineRebootRequired(cmdline string, args []string) bool {
+	wanted := make(map[string]bool)
+	for _, arg := range args {
+		wanted[arg] = true
+	}
+	present := make(map[string]bool)
+	for _, arg := range strings.Fields(cmdline) {
+		key := strings.SplitN(arg, "=", 2)[0]
+		for _, managed := range hostPrepKernelArgs {
+			if key == managed {
+				if !wanted[arg] {
+					return true
+				}
+				present[arg] = true
+			}
+		}
+	}
+	return len(present) != len(wanted)
+}
+
+func genGrubScript(args []string) []string {
+	return []string{
+		"#!/bin/bash",
+		"set -e",
+		fmt.Sprintf("ARGS=\"%s\"", strings.Join(args, " ")),
+		fmt.Sprintf("MANAGED=\"%s\"", strings.Join(hostPrepKernelArgs, " ")),
+		"if command -v grubby > /dev/null 2>&1 ; then",
+		"  grubby --update-kernel=ALL --remove-args=\"$MANAGED\"",
+		"  if [ -n \"$ARGS\" ] ; then grubby --update-kernel=ALL --args=\"$ARGS\" ; fi",
+		"else",
+		"  for k in $MANAGED ; do sed -i -E \"/^GRUB_CMDLINE_LINUX=/ s/ ?\\b$k=[^ \\\"]*//g\" /etc/default/grub ; done",
+		"  if [ -n \"$ARGS\" ] ; then sed -i -E \"s/^(GRUB_CMDLINE_LINUX=\\\"[^\\\"]*)\\\"/\\1 $ARGS\\\"/\" /etc/default/grub ; fi",
+		"  if command -v update-grub > /dev/null 2>&1 ; then update-grub",
+		"  elif [ -d /boot/grub2 ] ; then grub2-mkconfig -o /boot/grub2/grub.cfg",
+		"  else grub-mkconfig -o /boot/grub/grub.cfg ; fi",
+		"fi",
+	}
+}
+
+func updateKernelArgs(b *blx, args []string) error {
+	scriptPath := fmt.Sprintf("%s/host_prep_grub.sh", b.filePath["terraformInstallDir"])
+	err := createFileHost(b, scriptPath, genGrubScript(args))
+	if err != nil {
+		return err
+	}
+	_, err = execSudoCmdHost(b, fmt.Sprintf("bash %s", scriptPath))
+	if err != nil {
+		return fmt.Errorf("Error updating kernel arguments on host %s.\r\n%v", b.host["ipaddress"], err)
+	}
+	log.Printf("[INFO]  citrixblx-provider: Kernel arguments on host %s set to [%s]", b.host["ipaddress"], strings.Join(args, " "))
+	return nil
+}
+
+// hugepages unset leave the host reservation, possibly used by other
+// workloads, alone
+func updateHugepages(b *blx, prep hostPrep) error {
+	if prep.hugepages == 0 {
+		execSudoCmdHost(b, fmt.Sprintf("rm -f %s", hugepageSysctlFile))
+		return nil
+	}
+	if prep.hugepageSize == hugepageSize1G {
+		// 1G pages are reserved through the kernel cmdline, runtime allocation is best effort
+		execSudoCmdHost(b, fmt.Sprintf("echo %d > %s", prep.hugepages, hugepageSysfs(prep.hugepageSize)))
+		execSudoCmdHost(b, fmt.Sprintf("rm -f %s", hugepageSysctlFile))
+		return nil
+	}
+
+	err := createFileHost(b, hugepageSysctlFile, []string{fmt.Sprintf("vm.nr_hugepages = %d", prep.hugepages)})
+	if err != nil {
+		return err
+	}
+	_, err = execSudoCmdHost(b, fmt.Sprintf("sysctl -w vm.nr_hugepages=%d", prep.hugepages))
+	if err != nil {
+		return fmt.Errorf("Error setting hugepages on host %s.\r\n%v", b.host["ipaddress"], err)
+	}
+	return nil
+}
+
+func readHostPrep(b *blx, prep hostPrep) (hostPrepState, error) {
+	var state hostPrepState
+
+	out, err := execCmdHost(b, "cat /proc/cmdline")
+	if err != nil {
+		return state, fmt.Errorf("Unable to read kernel cmdline.\r\n%v", err)
+	}
+	state.cmdline = strings.TrimSpace(out)
+
+	out, err = execCmdHost(b, fmt.Sprintf("cat %s", hugepageSysfs(prep.hugepageSize)))
+	if err == nil {
+		state.hugepages, _ = strconv.Atoi(strings.TrimSpace(out))
+	}
+
+	args, err := hostPrepArgs(b, prep)
+	if err != nil {
+		return state, err
+	}
+	state.rebootRequired = cmdlineRebootRequired(state.cmdline, args)
+	return state, nil
+}
+
+// apply hugepage, IOMMU and isolcpus settings on the host and verify them
+func prepHost(b *blx, prep hostPrep) (hostPrepState, error) {
+	var state hostPrepState
+
+	args, err := hostPrepArgs(b, prep)
+	if err != nil {
+		return state, err
+	}
+	err = updateKernelArgs(b, args)
+	if err != nil {
+		return state, err
+	}
+	err = updateHugepages(b, prep)
+	if err != nil {
+		return state, err
+	}
+
+	state, err = readHostPrep(b, prep)
+	if err != nil {
+		return state, err
+	}
+	if state.rebootRequired && prep.reboot {
+		err = rebootHost(b)
+		if err != nil {
+			return state, err
+		}
+		state, err = readHostPrep(b, prep)
+		if err != nil {
+			return state, err
+		}
+	}
+
+	if state.rebootRequired {
+		if prep.reboot {
+			return state, fmt.Errorf("Kernel cmdline of host %s does not have [%s] after reboot - %s", b.host["ipaddress"], strings.Join(args, " "), state.cmdline)
+		}
+		log.Printf("[WARN]  citrixblx-provider: Host %s needs a reboot for kernel arguments [%s]", b.host["ipaddress"], strings.Join(args, " "))
+		return state, nil
+	}
+	if state.hugepages < prep.hugepages {
+		return state, fmt.Errorf("Host %s has %d %s hugepages, %d requested", b.host["ipaddress"], state.hugepages, prep.hugepageSize, prep.hugepages)
+	}
+
+	log.Printf("[INFO]  citrixblx-provider: Host %s prepared SUCCESS", b.host["ipaddress"])
+	return state, nil
+}
+
+// remove the persistent settings, takes effect on the next host reboot
+func unprepHost(b *blx) error {
+	execSudoCmdHost(b, fmt.Sprintf("rm -f %s", hugepageSysctlFile))
+	return updateKernelArgs(b, nil)
+}
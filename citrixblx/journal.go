@@ -0,0 +1,86 @@
+package citrixblx
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+)
+
+const (
+	phaseInstall     = "install"
+	phaseHostSetup   = "host_setup"
+	phaseClearConfig = "clear_config"
+	phaseMLX         = "mlx"
+)
+
+// hash of the inputs of a phase
+func phaseHash(inputs ...string) string {
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(strings.Join(inputs, "\n"))))
+}
+
+// identify a local file by path, size and modification time without reading
+// it, URLs and files not found locally are identified by their name only
+func fileInputID(path string) string {
+	if path == "" || isURL(path) {
+		return path
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return path
+	}
+	return fmt.Sprintf("%s:%d:%d", path, info.Size(), info.ModTime().Unix())
+}
+
+func installInputs(b *blx) string {
+	return phaseHash(fileInputID(b.source), fileInputID(b.depBundle), fmt.Sprintf("%t", b.enableEPEL), b.dist)
+}
+
+// journal lines are "<phase> <input hash>"
+func readJournal(b *blx) map[string]string {
+	journal := make(map[string]string)
+	out, err := execCmdHost(b, fmt.Sprintf("cat %s 2>/dev/null || true", b.filePath["journal"]))
+	if err != nil {
+		log.Printf("[WARN]  citrixblx-provider: Unable to read phase journal, running all phases. %v", err)
+		return journal
+	}
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 2 {
+			journal[fields[0]] = fields[1]
+		}
+	}
+	return journal
+}
+
+func journalDone(b *blx, phase string, hash string) error {
+	_, err := execCmdHost(b, fmt.Sprintf("touch %s ; sed -i '/^%s /d' %s ; echo '%s %s' >> %s", b.filePath["journal"], phase, b.filePath["journal"], phase, hash, b.filePath["journal"]))
+	if err != nil {
+		return fmt.Errorf("Unable to record phase %s in journal.\r\n%v", phase, err)
+	}
+	return nil
+}
+
+func journalReset(b *blx) {
+	execCmdHost(b, fmt.Sprintf("rm -f %s", b.filePath["journal"]))
+}
+
+// run a phase of setupBLX unless the journal has it done with the same
+// inputs, verify checks that the result of an earlier run is still there
+func runPhase(b *blx, phase string, hash string, verify func(b *blx) bool, run func(b *blx) error) error {
+	if readJournal(b)[phase] == hash && (verify == nil || verify(b)) {
+		log.Printf("[INFO]  citrixblx-provider: Phase %s already done with the same inputs on %s, skipping", phase, b.host["ipaddress"])
+		return nil
+	}
+	err := run(b)
+	if err != nil {
+		return err
+	}
+	return journalDone(b, phase, hash)
+}
+
+func blxServiceInstalled(b *blx) bool {
+	_, err := execSudoCmdHost(b, "systemctl list-unit-files | grep -q blx.service")
+	return err == nil
+}
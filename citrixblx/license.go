@@ -0,0 +1,329 @@
+package citrixblx
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const (
+	licenseCheckWarn = "warn"
+	licenseCheckFail = "fail"
+	licenseCheckOff  = "off"
+
+	licensePermanent = "permanent"
+)
+
+var licenseMACRegex = regexp.MustCompile(`^[0-9a-f]{12}$`)
+
+// one INCREMENT or FEATURE line of a FlexLM license file
+type licenseFeature struct {
+	file    string
+	name    string
+	vendor  string
+	version string
+	expiry  string
+	expires time.Time
+	hostIDs []string
+}
+
+// join continuation lines ending in a backslash
+func licenseLines(content string) []string {
+	var lines []string
+	cur := ""
+	for _, line := range strings.Split(strings.Replace(content, "\r", "", -1), "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasSuffix(line, "\\") {
+			cur += strings.TrimSuffix(line, "\\") + " "
+			continue
+		}
+		cur += line
+		if strings.TrimSpace(cur) != "" && !strings.HasPrefix(cur, "#") {
+			lines = append(lines, strings.TrimSpace(cur))
+		}
+		cur = ""
+	}
+	return lines
+}
+
+// split on whitespace, keeping quoted values such as HOSTID="id1 id2" together
+func licenseFields(line string) []string {
+	var fields []string
+	cur := ""
+	quoted := false
+	for _, c := range line {
+		switch {
+		case c == '"':
+			quoted = !quoted
+		case (c == ' ' || c == '\t') && !quoted:
+			if cur != "" {
+				fields = append(fields, cur)
+			}
+			cur = ""
+		default:
+			cur += string(c)
+		}
+	}
+	if cur != "" {
+		fields = append(fields, cur)
+	}
+	return fields
+}
+
+// expiry as dd-mmm-yyyy, year 0 and "permanent" never expire
+func parseLicenseExpiry(expiry string) (time.Time, error) {
+	if strings.EqualFold(expiry, licensePermanent) || expiry == "0" {
+		return time.Time{}, nil
+	}
+	parts := strings.Split(expiry, "-")
+	if len(parts) == 3 && strings.Trim(parts[2], "0") == "" {
+		return time.Time{}, nil
+	}
+	return time.Parse("2-Jan-2006", expiry)
+}
+
+func parseLicense(file string, content string) ([]licenseFeature, error) {
+	var features []licenseFeature
+	var serverIDs []string
+	for _, line := range licenseLines(content) {
+		fields := licenseFields(line)
+		switch strings.ToUpper(fields[0]) {
+		case "SERVER":
+			if len(fields) > 2 {
+				serverIDs = append(serverIDs, fields[2])
+			}
+		case "INCREMENT", "FEATURE":
+			if len(fields) < 5 {
+				return nil, fmt.Errorf("Invalid %s line in license file %s - %s", fields[0], file, line)
+			}
+			feature := licenseFeature{
+				file:    file,
+				name:    fields[1],
+				vendor:  fields[2],
+				version: fields[3],
+				expiry:  licensePermanent,
+			}
+			expires, err := parseLicenseExpiry(fields[4])
+			if err != nil {
+				return nil, fmt.Errorf("Invalid expiry %s for feature %s in license file %s", fields[4], feature.name, file)
+			}
+			if !expires.IsZero() {
+				feature.expires = expires
+				feature.expiry = expires.Format("2006-01-02")
+			}
+			for _, field := range fields[5:] {
+				if strings.HasPrefix(strings.ToUpper(field), "HOSTID=") {
+					feature.hostIDs = strings.Fields(field[len("HOSTID="):])
+				}
+			}
+			features = append(features, feature)
+		}
+	}
+
+	if len(features) == 0 {
+		return nil, fmt.Errorf("No INCREMENT or FEATURE lines found in license file %s", file)
+	}
+	// served features are bound to the host id of the SERVER line
+	for i := range features {
+		if features[i].hostIDs == nil {
+			features[i].hostIDs = serverIDs
+		}
+	}
+	return features, nil
+}
+
+func normalizeMAC(mac string) string {
+	return strings.ToLower(strings.NewReplacer(":", "", "-", "").Replace(mac))
+}
+
+// problems with a feature, expired or bound to a MAC not on the host.
+// host ids other than a MAC, such as ANY or HOSTNAME=, are not checked
+func checkLicenseFeature(feature licenseFeature, macs map[string]bool, now time.Time) []string {
+	var problems []string
+	// a license is valid through its expiry date
+	if !feature.expires.IsZero() && feature.expires.AddDate(0, 0, 1).Before(now) {
+		problems = append(problems, fmt.Sprintf("%s: feature %s expired on %s", feature.file, feature.name, feature.expiry))
+	}
+
+	var wanted []string
+	for _, id := range feature.hostIDs {
+		mac := normalizeMAC(id)
+		if !licenseMACRegex.MatchString(mac) {
+			return problems
+		}
+		if macs[mac] {
+			return problems
+		}
+		wanted = append(wanted, id)
+	}
+	if len(wanted) != 0 {
+		problems = append(problems, fmt.Sprintf("%s: feature %s is bound to host id %s, not found on the host", feature.file, feature.name, strings.Join(wanted, " ")))
+	}
+	return problems
+}
+
+// update and delete connect to the host only when BLX is not reachable,
+// the host session is opened here when it is not there yet
+func hostMACs(b *blx) (map[string]bool, error) {
+	if b.hostSession == nil {
+		var err error
+		b.hostSession, err = hostConnect(b.host)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to connect to host %s.\r\n%v", b.host["ipaddress"], err)
+		}
+	}
+	out, err := execCmdHost(b, nicFactsCmd)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to get interfaces of host %s.\r\n%v", b.host["ipaddress"], err)
+	}
+	macs := make(map[string]bool)
+	for _, nic := range parseNICFacts(out) {
+		macs[normalizeMAC(nic.mac)] = true
+	}
+	return macs, nil
+}
+
+// parse local license files and check them against the host before upload
+func validateLicenses(b *blx, files []string, mode string) ([]licenseFeature, error) {
+	var features []licenseFeature
+	for _, file := range files {
+		// licenses downloaded by the host are not read locally
+		if isURL(file) {
+			log.Printf("[DEBUG]  citrixblx-provider: License %s is a URL, not checked", file)
+			continue
+		}
+		content, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to read license file %s, Error = %v", file, err)
+		}
+		parsed, err := parseLicense(filepath.Base(file), string(content))
+		if err != nil {
+			return nil, err
+		}
+		features = append(features, parsed...)
+	}
+	if mode == licenseCheckOff || len(features) == 0 {
+		return features, nil
+	}
+
+	macs, err := hostMACs(b)
+	if err != nil {
+		return nil, err
+	}
+	var problems []string
+	for _, feature := range features {
+		problems = append(problems, checkLicenseFeature(feature, macs, time.Now())...)
+	}
+	if len(problems) == 0 {
+		log.Printf("[DEBUG]  citrixblx-provider: License check SUCCESS on host %s", b.host["ipaddress"])
+		return features, nil
+	}
+	if mode == licenseCheckFail {
+		return nil, fmt.Errorf("License check failed for host %s\n - %s", b.host["ipaddress"], strings.Join(problems, "\n - "))
+	}
+	for _, problem := range problems {
+		log.Printf("[WARN]  citrixblx-provider: License check, %s", problem)
+	}
+	return features, nil
+}
+
+func validateLicenseCheck(mode string) error {
+	if mode != licenseCheckWarn && mode != licenseCheckFail && mode != licenseCheckOff {
+		return fmt.Errorf("license_check must be %s, %s or %s", licenseCheckWarn, licenseCheckFail, licenseCheckOff)
+	}
+	return nil
+}
+
+func localFileChecksum(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("Unable to open license file %s, Error = %v", path, err)
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	_, err = io.Copy(hash, file)
+	if err != nil {
+		return "", fmt.Errorf("Unable to read license file %s, Error = %v", path, err)
+	}
+	return fmt.Sprintf("%x", hash.Sum(nil)), nil
+}
+
+// checksum of a license file on the host, empty when not present
+func hostLicenseChecksum(b *blx, name string) (string, error) {
+	out, err := execSudoCmdHost(b, fmt.Sprintf("test -f %s/%s && sha256sum %s/%s || true", blxLicensePath, name, blxLicensePath, name))
+	if err != nil {
+		return "", err
+	}
+	fields := strings.Fields(out)
+	if len(fields) == 0 {
+		return "", nil
+	}
+	return fields[0], nil
+}
+
+func uploadLicense(b *blx, source string) error {
+	_, err := execCmdHost(b, fmt.Sprintf("rm -rf %s ; mkdir -p %s", b.filePath["licenseDir"], b.filePath["licenseDir"]))
+	if err != nil {
+		return err
+	}
+	err = getFile(b.hostSession, source, b.filePath["licenseDir"])
+	if err != nil {
+		return fmt.Errorf("Error copying license file = %s, Error = %v", source, err)
+	}
+
+	name := filepath.Base(source)
+	_, err = execSudoCmdHost(b, fmt.Sprintf("mkdir -p %s ; mv -f %s/%s %s/%s", blxLicensePath, b.filePath["licenseDir"], name, blxLicensePath, name))
+	if err != nil {
+		return err
+	}
+	_, err = execSudoCmdHost(b, fmt.Sprintf("chown nsroot %s/%s", blxLicensePath, name))
+	if err != nil {
+		return err
+	}
+	execSudoCmdHost(b, fmt.Sprintf("rm -rf %s", b.filePath["licenseDir"]))
+
+	log.Printf("[INFO]  citrixblx-provider: License %s uploaded to %s", name, b.host["ipaddress"])
+	return nil
+}
+
+func removeLicense(b *blx, name string) error {
+	_, err := execSudoCmdHost(b, fmt.Sprintf("rm -f %s/%s", blxLicensePath, name))
+	if err != nil {
+		return fmt.Errorf("Error removing license file %s.\r\n%v", name, err)
+	}
+	log.Printf("[INFO]  citrixblx-provider: License %s removed from %s", name, b.host["ipaddress"])
+	return nil
+}
+
+// licenses are read when BLX starts, a stopped BLX picks them up on its next start
+func reloadLicense(b *blx) error {
+	num, err := blxProcessCount(b)
+	if err != nil {
+		return err
+	}
+	if num == 0 {
+		log.Printf("[DEBUG]  citrixblx-provider: BLX not running on %s, license applied on next start", b.host["ipaddress"])
+		return nil
+	}
+
+	// a restart of the service is enough, BLX keeps its config and
+	// interfaces and is not drained
+	log.Printf("[INFO]  citrixblx-provider: Restarting BLX %s to apply license change", b.id)
+	_, err = execSudoCmdHost(b, "systemctl restart blx")
+	if err != nil {
+		return fmt.Errorf("Error restarting BLX %s for the license change.\r\n%v", b.id, err)
+	}
+	err = checkBLXProcess(b)
+	if err != nil {
+		return err
+	}
+	return checkBLXIP(b)
+}
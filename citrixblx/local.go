@@ -0,0 +1,75 @@
+package citrixblx
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+)
+
+const (
+	connectionSSH   = "ssh"
+	connectionLocal = "local"
+)
+
+// commands and copies on the machine terraform runs on
+type localTransport struct{}
+
+func (t localTransport) Run(cmd string) (string, error) {
+	out, err := exec.Command("bash", "-c", cmd).CombinedOutput()
+	return string(out), err
+}
+
+func (t localTransport) Copy(source string, dest string) error {
+	return copyLocalFile(source, dest)
+}
+
+func (t localTransport) Close() error {
+	return nil
+}
+
+func validateConnection(host map[string]string) error {
+	if host["connection"] != "" && host["connection"] != connectionSSH && host["connection"] != connectionLocal {
+		return fmt.Errorf("Host connection must be %s or %s", connectionSSH, connectionLocal)
+	}
+	return nil
+}
+
+func isLocalHost(host map[string]string) bool {
+	return host["connection"] == connectionLocal
+}
+
+// copy like scp, into destFilePath when it is a directory
+func copyLocalFile(sourceFilePath string, destFilePath string) error {
+	info, err := os.Stat(destFilePath)
+	if err == nil && info.IsDir() {
+		destFilePath = filepath.Join(destFilePath, filepath.Base(sourceFilePath))
+	}
+
+	log.Printf("[DEBUG] citrixblx-provider: Copying file from %s to %s", sourceFilePath, destFilePath)
+	src, err := os.Open(sourceFilePath)
+	if err != nil {
+		return fmt.Errorf("Error copying file from %s to %s, Error = %v", sourceFilePath, destFilePath, err)
+	}
+	defer src.Close()
+	srcInfo, err := src.Stat()
+	if err != nil {
+		return fmt.Errorf("Error copying file from %s to %s, Error = %v", sourceFilePath, destFilePath, err)
+	}
+
+	dest, err := os.OpenFile(destFilePath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, srcInfo.Mode().Perm())
+	if err != nil {
+		return fmt.Errorf("Error copying file from %s to %s, Error = %v", sourceFilePath, destFilePath, err)
+	}
+	_, err = io.Copy(dest, src)
+	if errClose := dest.Close(); err == nil {
+		err = errClose
+	}
+	if err != nil {
+		log.Printf("[ERROR] citrixblx-provider: Failed to copy file from %s, to %s", sourceFilePath, destFilePath)
+		return fmt.Errorf("Error copying file from %s to %s, Error = %v", sourceFilePath, destFilePath, err)
+	}
+	return nil
+}
@@ -0,0 +1,179 @@
+package citrixblx
+
+import (
+	"crypto/rand"
+	"fmt"
+	"log"
+	"os"
+	"os/user"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	hostLockDir  = "/var/lib/citrixblx"
+	hostLockFile = hostLockDir + "/host.lock"
+
+	lockStaleAfter  = 3 * time.Hour
+	lockWaitTimeout = 30 * time.Minute
+)
+
+// id of a lock to remove, the force_unlock argument of the provider
+var forceUnlockID string
+
+// lock file content - "id=<id> owner=<user>@<host> pid=<pid> time=<unix time>"
+type hostLock struct {
+	id      string
+	owner   string
+	pid     int
+	created time.Time
+}
+
+func (l hostLock) String() string {
+	return fmt.Sprintf("id=%s owner=%s pid=%d time=%d", l.id, l.owner, l.pid, l.created.Unix())
+}
+
+func parseHostLock(out string) (hostLock, bool) {
+	var lock hostLock
+	for _, field := range strings.Fields(out) {
+		kv := strings.SplitN(field, "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		switch kv[0] {
+		case "id":
+			lock.id = kv[1]
+		case "owner":
+			lock.owner = kv[1]
+		case "pid":
+			lock.pid, _ = strconv.Atoi(kv[1])
+		case "time":
+			sec, _ := strconv.ParseInt(kv[1], 10, 64)
+			lock.created = time.Unix(sec, 0)
+		}
+	}
+	return lock, lock.id != ""
+}
+
+func lockOwner() string {
+	name := "unknown"
+	if u, err := user.Current(); err == nil {
+		name = u.Username
+	}
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+	return strings.NewReplacer("\\", "/", " ", "_").Replace(fmt.Sprintf("%s@%s", name, host))
+}
+
+func newHostLock() hostLock {
+	buf := make([]byte, 8)
+	rand.Read(buf)
+	return hostLock{
+		id:      fmt.Sprintf("%x", buf),
+		owner:   lockOwner(),
+		pid:     os.Getpid(),
+		created: time.Now(),
+	}
+}
+
+func processAlive(pid int) bool {
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	if runtime.GOOS == "windows" {
+		return true
+	}
+	return p.Signal(syscall.Signal(0)) == nil
+}
+
+// stale when too old, or held by a process of this machine that is gone
+func hostLockStale(lock hostLock) bool {
+	if time.Since(lock.created) > lockStaleAfter {
+		return true
+	}
+	return lock.owner == lockOwner() && lock.pid != os.Getpid() && !processAlive(lock.pid)
+}
+
+// run a lock command as root, through the NS shell when the host
+// is only reachable through BLX
+func execLockCmd(b *blx, cmd string) (string, error) {
+	if b.hostSession != nil {
+		return execSudoCmdHost(b, cmd)
+	}
+	if b.nsSession != nil {
+		return runNSShellCmd(b.nsSession, cmd)
+	}
+	return "", fmt.Errorf("No session to host %s for the host lock", b.host["ipaddress"])
+}
+
+func readHostLock(b *blx) (hostLock, bool, error) {
+	out, err := execLockCmd(b, fmt.Sprintf("cat %s 2>/dev/null || true", hostLockFile))
+	if err != nil {
+		return hostLock{}, false, err
+	}
+	lock, ok := parseHostLock(out)
+	return lock, ok, nil
+}
+
+// take the exclusive lock of the host, the lock file is created with
+// noclobber so only one of several concurrent runs gets it
+func lockHost(b *blx) error {
+	lock := newHostLock()
+	for start := time.Now(); ; sleep(15 * time.Second) {
+		_, err := execLockCmd(b, fmt.Sprintf("mkdir -p %s && set -C && echo %s > %s", hostLockDir, lock, hostLockFile))
+		if err == nil {
+			b.lockID = lock.id
+			log.Printf("[DEBUG]  citrixblx-provider: Host %s locked, %s", b.host["ipaddress"], lock)
+			return nil
+		}
+
+		held, ok, errRead := readHostLock(b)
+		if errRead != nil {
+			return errRead
+		}
+		if !ok {
+			if time.Since(start) > lockWaitTimeout {
+				return fmt.Errorf("Unable to lock host %s.\r\n%v", b.host["ipaddress"], err)
+			}
+			continue
+		}
+		if forceUnlockID != "" && held.id == forceUnlockID {
+			log.Printf("[WARN]  citrixblx-provider: Removing lock of host %s by force_unlock, %s", b.host["ipaddress"], held)
+			execLockCmd(b, fmt.Sprintf("rm -f %s", hostLockFile))
+			continue
+		}
+		if hostLockStale(held) {
+			log.Printf("[WARN]  citrixblx-provider: Removing stale lock of host %s, %s", b.host["ipaddress"], held)
+			execLockCmd(b, fmt.Sprintf("rm -f %s", hostLockFile))
+			continue
+		}
+		if time.Since(start) > lockWaitTimeout {
+			return fmt.Errorf("Host %s is locked by %s pid %d since %s. If no other run is active, set force_unlock = \"%s\" in the provider",
+				b.host["ipaddress"], held.owner, held.pid, held.created.Format(time.RFC3339), held.id)
+		}
+		log.Printf("[INFO]  citrixblx-provider: Host %s is locked by %s pid %d, waiting", b.host["ipaddress"], held.owner, held.pid)
+	}
+}
+
+// release the lock, unless it was taken over in the meantime
+func unlockHost(b *blx) {
+	if b.lockID == "" {
+		return
+	}
+	held, ok, err := readHostLock(b)
+	if err != nil {
+		log.Printf("[WARN]  citrixblx-provider: Unable to release lock of host %s, %v", b.host["ipaddress"], err)
+		return
+	}
+	if ok && held.id == b.lockID {
+		execLockCmd(b, fmt.Sprintf("rm -f %s", hostLockFile))
+		log.Printf("[DEBUG]  citrixblx-provider: Host %s unlocked", b.host["ipaddress"])
+	}
+	b.lockID = ""
+}
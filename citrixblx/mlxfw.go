@@ -0,0 +1,179 @@
+package citrixblx
+
+import (
+	"fmt"
+	"log"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var (
+	mlxconfigParamRegex = regexp.MustCompile(`^\s+([A-Z0-9_]+)\s+(\S+)`)
+	flintVersionRegex   = regexp.MustCompile(`(?m)^FW Version:\s+(\S+)`)
+)
+
+type mlxFirmware struct {
+	device        string
+	sriovEn       string
+	numOfVFs      int
+	linkType      string
+	firmwareImage string
+}
+
+func getMLXFirmwareInfo(list []interface{}) []mlxFirmware {
+	fws := make([]mlxFirmware, 0, len(list))
+	for _, i := range list {
+		m := i.(map[string]interface{})
+		fws = append(fws, mlxFirmware{
+			device:        m["device"].(string),
+			sriovEn:       m["sriov_en"].(string),
+			numOfVFs:      m["num_of_vfs"].(int),
+			linkType:      m["link_type"].(string),
+			firmwareImage: m["firmware_image"].(string),
+		})
+	}
+	return fws
+}
+
+// parse the current configuration from mlxconfig query output,
+// values look like "True(1)", "ETH(2)" or "8"
+func parseMlxconfigQuery(out string) map[string]string {
+	params := make(map[string]string)
+	for _, line := range strings.Split(out, "\n") {
+		match := mlxconfigParamRegex.FindStringSubmatch(line)
+		if match != nil {
+			params[match[1]] = match[2]
+		}
+	}
+	return params
+}
+
+func mlxconfigValueMatch(current string, wanted string) bool {
+	current = strings.ToLower(current)
+	wanted = strings.ToLower(wanted)
+	if current == wanted {
+		return true
+	}
+	if i := strings.Index(current, "("); i > 0 && strings.HasSuffix(current, ")") {
+		return current[:i] == wanted || current[i+1:len(current)-1] == wanted
+	}
+	return false
+}
+
+// mlxconfig parameters that differ from the wanted settings
+func mlxconfigChanges(fw mlxFirmware, current map[string]string) []string {
+	wanted := make(map[string]string)
+	if fw.sriovEn != "" {
+		wanted["SRIOV_EN"] = fw.sriovEn
+	}
+	if fw.numOfVFs != 0 {
+		wanted["NUM_OF_VFS"] = strconv.Itoa(fw.numOfVFs)
+	}
+	if fw.linkType != "" {
+		wanted["LINK_TYPE_P1"] = fw.linkType
+		if _, ok := current["LINK_TYPE_P2"]; ok {
+			wanted["LINK_TYPE_P2"] = fw.linkType
+		}
+	}
+
+	var changes []string
+	for param, val := range wanted {
+		if !mlxconfigValueMatch(current[param], val) {
+			changes = append(changes, fmt.Sprintf("%s=%s", param, val))
+		}
+	}
+	sort.Strings(changes)
+	return changes
+}
+
+func flintFwVersion(b *blx, flintArgs string) (string, error) {
+	out, err := execSudoCmdHost(b, fmt.Sprintf("flint %s q", flintArgs))
+	if err != nil {
+		return "", err
+	}
+	match := flintVersionRegex.FindStringSubmatch(out)
+	if match == nil {
+		return "", fmt.Errorf("Unable to find firmware version in flint output -\n%s", out)
+	}
+	return match[1], nil
+}
+
+func burnMLXFirmware(b *blx, fw mlxFirmware) error {
+	fwDir := fmt.Sprintf("%s/firmware", b.filePath["mlxDir"])
+	err := getFile(b.hostSession, fw.firmwareImage, fwDir)
+	if err != nil {
+		return err
+	}
+	image := fmt.Sprintf("%s/%s", fwDir, filepath.Base(fw.firmwareImage))
+
+	imageVersion, err := flintFwVersion(b, fmt.Sprintf("-i %s", image))
+	if err != nil {
+		return err
+	}
+	deviceVersion, err := flintFwVersion(b, fmt.Sprintf("-d %s", fw.device))
+	if err != nil {
+		return err
+	}
+	if imageVersion == deviceVersion {
+		log.Printf("[DEBUG]  citrixblx-provider: Firmware %s already on %s", deviceVersion, fw.device)
+		return nil
+	}
+
+	log.Printf("[INFO]  citrixblx-provider: Burning firmware %s on %s, current %s", imageVersion, fw.device, deviceVersion)
+	_, err = execSudoCmdHost(b, fmt.Sprintf("flint -d %s -i %s -y burn", fw.device, image))
+	if err != nil {
+		return fmt.Errorf("Error burning firmware %s on %s.\r\n%v", image, fw.device, err)
+	}
+	b.rebootRequired = true
+	return nil
+}
+
+// apply mlxconfig settings and firmware with the installed MFT tools,
+// then record the firmware version of every device
+func configureMLXFirmware(b *blx) error {
+	if len(b.mlxFirmware) == 0 {
+		return nil
+	}
+	_, err := execSudoCmdHost(b, "command -v mlxconfig && command -v flint")
+	if err != nil {
+		return fmt.Errorf("mlx_firmware needs the Mellanox firmware tools, set mlx_tools.\r\n%v", err)
+	}
+	execSudoCmdHost(b, "mst start")
+
+	b.mlxFwVersion = make(map[string]string)
+	for _, fw := range b.mlxFirmware {
+		if fw.firmwareImage != "" {
+			err = burnMLXFirmware(b, fw)
+			if err != nil {
+				return err
+			}
+		}
+
+		out, err := execSudoCmdHost(b, fmt.Sprintf("mlxconfig -d %s q", fw.device))
+		if err != nil {
+			return fmt.Errorf("Error querying mlxconfig of %s.\r\n%v", fw.device, err)
+		}
+		changes := mlxconfigChanges(fw, parseMlxconfigQuery(out))
+		if len(changes) != 0 {
+			out, err = execSudoCmdHost(b, fmt.Sprintf("mlxconfig -d %s -y set %s", fw.device, strings.Join(changes, " ")))
+			if err != nil {
+				return fmt.Errorf("Error setting mlxconfig %s on %s.\r\n%v", strings.Join(changes, " "), fw.device, err)
+			}
+			log.Printf("[INFO]  citrixblx-provider: mlxconfig %s set on %s", strings.Join(changes, " "), fw.device)
+			if strings.Contains(strings.ToLower(out), "reboot") {
+				b.rebootRequired = true
+			}
+		}
+
+		version, err := flintFwVersion(b, fmt.Sprintf("-d %s", fw.device))
+		if err != nil {
+			return err
+		}
+		b.mlxFwVersion[fw.device] = version
+	}
+
+	return rebootIfRequired(b)
+}
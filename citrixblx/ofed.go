@@ -0,0 +1,116 @@
+package citrixblx
+
+import (
+	"fmt"
+	"log"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	ofedMountPoint = "/mnt/citrixblx-ofed"
+	ofedNamePrefix = "MLNX_OFED_LINUX-"
+)
+
+// OFED version from a name such as MLNX_OFED_LINUX-5.8-1.1.2.1-rhel8.6-x86_64.iso
+// or from the ofed_info -s output MLNX_OFED_LINUX-5.8-1.1.2.1:
+func ofedVersion(name string) string {
+	i := strings.Index(name, ofedNamePrefix)
+	if i < 0 {
+		return ""
+	}
+	parts := strings.SplitN(strings.TrimSpace(name[i+len(ofedNamePrefix):]), "-", 3)
+	if len(parts) < 2 {
+		return ""
+	}
+	return strings.TrimSuffix(parts[0]+"-"+parts[1], ":")
+}
+
+func installedOFEDVersion(b *blx) string {
+	out, err := execSudoCmdHost(b, "ofed_info -s")
+	if err != nil {
+		return ""
+	}
+	return ofedVersion(out)
+}
+
+// install the OFED ISO, skipped when the same version is already installed
+func installOFED(b *blx) error {
+	installed := installedOFEDVersion(b)
+	isoVersion := ofedVersion(filepath.Base(b.mlx["ofed"]))
+	if installed != "" && installed == isoVersion {
+		log.Printf("[INFO]  citrixblx-provider: OFED %s already installed on %s, skipping install", installed, b.host["ipaddress"])
+		return nil
+	}
+
+	// --add-kernel-support builds against the running kernel headers, they
+	// are installed before the large ISO upload so that a missing package
+	// fails early
+	err := requirePkgManager(b)
+	if err != nil {
+		return err
+	}
+	_, err = execPkgCmdHost(b, b.pkg.kernelHeaders())
+	if err != nil {
+		return fmt.Errorf("Unable to install kernel headers for the running kernel with %s, needed by OFED install.\r\n%v", b.pkg.name(), err)
+	}
+
+	ofedDir := fmt.Sprintf("%s/ofed", b.filePath["mlxDir"])
+	execSudoCmdHost(b, fmt.Sprintf("rm -rf %s", ofedDir))
+	err = getFile(b.hostSession, b.mlx["ofed"], ofedDir)
+	if err != nil {
+		return err
+	}
+
+	iso := filepath.Base(b.mlx["ofed"])
+	if strings.HasSuffix(iso, ".gz") {
+		_, err := execSudoCmdHost(b, fmt.Sprintf("gunzip %s/%s", ofedDir, iso))
+		if err != nil {
+			return err
+		}
+		iso = strings.TrimSuffix(iso, ".gz")
+	}
+
+	// mount the mellanox OFED iso
+	execSudoCmdHost(b, fmt.Sprintf("umount -f %s ; mkdir -p %s", ofedMountPoint, ofedMountPoint))
+	_, err = execSudoCmdHost(b, fmt.Sprintf("mount -o ro,loop %s/%s %s", ofedDir, iso, ofedMountPoint))
+	if err != nil {
+		return err
+	}
+	defer execSudoCmdHost(b, fmt.Sprintf("umount -f %s", ofedMountPoint))
+
+	if isoVersion == "" {
+		out, err := execSudoCmdHost(b, fmt.Sprintf("cat %s/.mlnx", ofedMountPoint))
+		if err == nil {
+			isoVersion = strings.TrimSpace(out)
+			if v := ofedVersion(out); v != "" {
+				isoVersion = v
+			}
+		}
+		if installed != "" && installed == isoVersion {
+			log.Printf("[INFO]  citrixblx-provider: OFED %s already installed on %s, skipping install", installed, b.host["ipaddress"])
+			return nil
+		}
+	}
+	log.Printf("[INFO]  citrixblx-provider: Installing OFED %s on %s, installed version [%s]", isoVersion, b.host["ipaddress"], installed)
+
+	// run the install
+	ofedInstallCmd := fmt.Sprintf("%s/mlnxofedinstall --add-kernel-support --skip-repo --skip-distro-check --skip-unsupported-devices-check", ofedMountPoint)
+	out, err := execSudoCmdHost(b, ofedInstallCmd)
+	if err != nil {
+		log.Printf("[WARN]  citrixblx-provider: output = %s", out)
+		if !strings.Contains(out, "Current operation system is not supported") {
+			return err
+		}
+		if b.distro == "" || b.distroVersion == "" {
+			log.Printf("[ERROR] Unable to detect OS distro for OFED Installation. Output of OFED Installation =\r\n%v", out)
+			return err
+		}
+		_, err = execSudoCmdHost(b, fmt.Sprintf("%s --distro %s%s", ofedInstallCmd, b.distro, b.distroVersion))
+		if err != nil {
+			return err
+		}
+	}
+	b.rebootRequired = true
+	return nil
+}
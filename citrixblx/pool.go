@@ -0,0 +1,168 @@
+package citrixblx
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"golang.org/x/crypto/ssh"
+	"log"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	defaultKeepalive   = 30
+	defaultMaxSessions = 8
+
+	// a connection that does not answer a keepalive within this is dropped
+	connAliveTimeout = 5 * time.Second
+)
+
+// cached SSH client, closed by its keepalive loop when the connection is gone
+type pooledConn struct {
+	client *ssh.Client
+	done   chan struct{}
+}
+
+// SSH clients shared by all resources of the provider, keyed by user,
+// address and credentials. Commands run as sessions multiplexed on the
+// shared client, at most maxSessions at a time per host
+type connPool struct {
+	mu          sync.Mutex
+	conns       map[string]*pooledConn
+	hostSlots   map[string]chan struct{}
+	keepalive   time.Duration
+	maxSessions int
+}
+
+var connCache = newConnPool(defaultKeepalive, defaultMaxSessions)
+
+func newConnPool(keepalive int, maxSessions int) *connPool {
+	p := &connPool{
+		conns:     make(map[string]*pooledConn),
+		hostSlots: make(map[string]chan struct{}),
+	}
+	p.configure(keepalive, maxSessions)
+	return p
+}
+
+func (p *connPool) configure(keepalive int, maxSessions int) {
+	if maxSessions < 1 {
+		maxSessions = 1
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.keepalive = time.Duration(keepalive) * time.Second
+	// slots already handed out keep their size, sessions may hold them
+	p.maxSessions = maxSessions
+}
+
+func connKey(user string, address string, port string, secrets ...string) string {
+	hash := sha256.New()
+	for _, secret := range secrets {
+		fmt.Fprintf(hash, "%s\n", secret)
+	}
+	return fmt.Sprintf("%s@%s %x", user, net.JoinHostPort(address, port), hash.Sum(nil))
+}
+
+// a half-open connection never answers, the request is not waited for
+// longer than connAliveTimeout and the caller drops the client, which
+// also ends the pending request
+func connAlive(client *ssh.Client) bool {
+	reply := make(chan error, 1)
+	go func() {
+		_, _, err := client.SendRequest("keepalive@openssh.com", true, nil)
+		reply <- err
+	}()
+	select {
+	case err := <-reply:
+		return err == nil
+	case <-time.After(connAliveTimeout):
+		return false
+	}
+}
+
+// return the cached client for key while it is alive, dial a new one otherwise
+func (p *connPool) get(key string, dial func() (*ssh.Client, error)) (*ssh.Client, error) {
+	p.mu.Lock()
+	conn, ok := p.conns[key]
+	p.mu.Unlock()
+	if ok {
+		if connAlive(conn.client) {
+			return conn.client, nil
+		}
+		p.drop(key, conn)
+	}
+
+	client, err := dial()
+	if err != nil {
+		return client, err
+	}
+
+	p.mu.Lock()
+	if other, ok := p.conns[key]; ok {
+		// dialed concurrently by another resource
+		p.mu.Unlock()
+		client.Close()
+		return other.client, nil
+	}
+	conn = &pooledConn{client: client, done: make(chan struct{})}
+	p.conns[key] = conn
+	keepalive := p.keepalive
+	p.mu.Unlock()
+
+	if keepalive > 0 {
+		go p.keepaliveLoop(key, conn, keepalive)
+	}
+	return client, nil
+}
+
+// keep idle connections up during long host commands such as the OFED install
+func (p *connPool) keepaliveLoop(key string, conn *pooledConn, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-conn.done:
+			return
+		case <-ticker.C:
+			if !connAlive(conn.client) {
+				log.Printf("[DEBUG]  citrixblx-provider: Connection %s lost, removing from cache", conn.client.RemoteAddr())
+				p.drop(key, conn)
+				return
+			}
+		}
+	}
+}
+
+func (p *connPool) drop(key string, conn *pooledConn) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.conns[key] != conn {
+		return
+	}
+	delete(p.conns, key)
+	close(conn.done)
+	conn.client.Close()
+}
+
+// take one of the session slots of the host of client, the returned
+// function gives it back
+func (p *connPool) acquire(client *ssh.Client) func() {
+	host, _, err := net.SplitHostPort(client.RemoteAddr().String())
+	if err != nil {
+		host = client.RemoteAddr().String()
+	}
+	p.mu.Lock()
+	slots, ok := p.hostSlots[host]
+	if !ok {
+		slots = make(chan struct{}, p.maxSessions)
+		p.hostSlots[host] = slots
+	}
+	p.mu.Unlock()
+
+	slots <- struct{}{}
+	return func() {
+		<-slots
+	}
+}
@@ -0,0 +1,192 @@
+package citrixblx
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultLicenseServerPort = 27000
+	pooledLicenseTimeout     = 5 * time.Minute
+)
+
+type pooledLicense struct {
+	server        string
+	port          int
+	edition       string
+	bandwidth     int
+	instanceCount int
+}
+
+func getPooledLicenseInfo(list []interface{}) *pooledLicense {
+	if len(list) == 0 || list[0] == nil {
+		return nil
+	}
+	m := list[0].(map[string]interface{})
+	return &pooledLicense{
+		server:        m["server"].(string),
+		port:          m["port"].(int),
+		edition:       m["edition"].(string),
+		bandwidth:     m["bandwidth"].(int),
+		instanceCount: m["instance_count"].(int),
+	}
+}
+
+func validatePooledLicense(pl *pooledLicense) error {
+	if pl == nil {
+		return nil
+	}
+	if pl.server == "" {
+		return fmt.Errorf("pooled_license server must be set")
+	}
+	if pl.instanceCount < 0 || pl.bandwidth < 0 {
+		return fmt.Errorf("pooled_license bandwidth and instance_count cannot be negative")
+	}
+	if pl.bandwidth != 0 && pl.instanceCount != 0 {
+		return fmt.Errorf("Only one of bandwidth and instance_count can be set in pooled_license")
+	}
+	return nil
+}
+
+// cli-cmds for blx.conf, bandwidth pooled when bandwidth is set, vCPU pooled
+// otherwise with instance_count vCPU licenses when it is set
+func genPooledLicenseCmds(pl *pooledLicense) []string {
+	if pl == nil {
+		return nil
+	}
+	cmds := []string{fmt.Sprintf("add ns licenseserver %s -port %d", pl.server, pl.port)}
+	if pl.bandwidth != 0 {
+		cmds = append(cmds, fmt.Sprintf("set ns capacity -unit Mbps -bandwidth %d -edition %s", pl.bandwidth, pl.edition))
+	} else if pl.instanceCount != 0 {
+		cmds = append(cmds, fmt.Sprintf("set ns capacity -vcpu -vcpuCount %d -edition %s", pl.instanceCount, pl.edition))
+	} else {
+		cmds = append(cmds, fmt.Sprintf("set ns capacity -vcpu -edition %s", pl.edition))
+	}
+	return cmds
+}
+
+// pooled licensing configured through cli_cmd instead of pooled_license
+func cliCmdLicenseServer(b *blx) bool {
+	for _, cmd := range b.cliCmd {
+		if strings.Contains(strings.ToLower(cmd), "licenseserver") {
+			return true
+		}
+	}
+	return false
+}
+
+// parse "Key: value" pairs of NS show output, pairs are tab separated
+func parseNSShow(out string) map[string]string {
+	values := make(map[string]string)
+	for _, line := range strings.Split(out, "\n") {
+		for _, field := range strings.Split(line, "\t") {
+			i := strings.Index(field, ":")
+			if i <= 0 {
+				continue
+			}
+			key := strings.ToLower(strings.TrimSpace(field[:i]))
+			if _, ok := values[key]; !ok {
+				values[key] = strings.TrimSpace(field[i+1:])
+			}
+		}
+	}
+	return values
+}
+
+func getLicenseStatus(b *blx) (map[string]string, error) {
+	client, err := nsConnect(b)
+	if err != nil {
+		return nil, err
+	}
+
+	out, err := runNSCmd(client, "show ns licenseserver")
+	if err != nil {
+		return nil, err
+	}
+	server := parseNSShow(out)
+	out, err = runNSCmd(client, "show ns capacity")
+	if err != nil {
+		return nil, err
+	}
+	capacity := parseNSShow(out)
+	out, err = runNSCmd(client, "show license")
+	if err != nil {
+		return nil, err
+	}
+	license := parseNSShow(out)
+
+	status := map[string]string{
+		"server":        server["servername"],
+		"server_status": "disconnected",
+		"edition":       capacity["edition"],
+		"bandwidth":     capacity["actualbandwidth"],
+		"vcpu_count":    capacity["vcpucount"],
+		"license_type":  license["license type"],
+	}
+	if server["status"] == "1" {
+		status["server_status"] = "connected"
+	}
+	return status, nil
+}
+
+// capacity checked out from the license server matches the pooled_license block
+func pooledCapacityApplied(pl *pooledLicense, status map[string]string) bool {
+	bandwidth, _ := strconv.Atoi(status["bandwidth"])
+	vcpus, _ := strconv.Atoi(status["vcpu_count"])
+	if pl == nil {
+		return bandwidth > 0 || vcpus > 0
+	}
+	if !strings.EqualFold(status["edition"], pl.edition) {
+		return false
+	}
+	if pl.bandwidth != 0 {
+		return bandwidth == pl.bandwidth
+	}
+	if pl.instanceCount != 0 {
+		return vcpus == pl.instanceCount
+	}
+	return vcpus > 0
+}
+
+// wait for BLX to connect to the license server and check out its capacity.
+// Capacity set at boot is applied on the next restart, BLX is restarted
+// once if the server is connected and the capacity is still not applied
+func waitPooledLicense(b *blx) error {
+	restarted := false
+	var status map[string]string
+	var err error
+	for waited := time.Duration(0); waited < pooledLicenseTimeout; waited += pause(10 * time.Second) {
+		status, err = getLicenseStatus(b)
+		if err != nil {
+			log.Printf("[WARN]  citrixblx-provider: Unable to get license status of %s, waiting. %v", b.id, err)
+			continue
+		}
+		b.licenseStatus = status
+		if status["server_status"] != "connected" {
+			log.Printf("[DEBUG]  citrixblx-provider: BLX %s not connected to license server %s, waiting", b.id, status["server"])
+			continue
+		}
+		if pooledCapacityApplied(b.pooledLicense, status) {
+			log.Printf("[INFO]  citrixblx-provider: Pooled license %s applied on BLX %s SUCCESS", status["edition"], b.id)
+			return nil
+		}
+		if !restarted {
+			log.Printf("[DEBUG]  citrixblx-provider: Pooled capacity not applied on BLX %s, restarting BLX", b.id)
+			err = restartBLX(b)
+			if err != nil {
+				return err
+			}
+			restarted = true
+			waited = 0
+		}
+	}
+
+	if status == nil {
+		return fmt.Errorf("Unable to get license status of BLX %s within %v.\r\n%v", b.id, pooledLicenseTimeout, err)
+	}
+	return fmt.Errorf("Pooled license not applied on BLX %s within %v, license server %s %s, edition %s, bandwidth %s, vcpu count %s",
+		b.id, pooledLicenseTimeout, status["server"], status["server_status"], status["edition"], status["bandwidth"], status["vcpu_count"])
+}
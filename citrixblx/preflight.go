@@ -0,0 +1,253 @@
+package citrixblx
+
+import (
+	"fmt"
+	"log"
+	"math/big"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	preflightOK = "ok"
+
+	// minimum free space in KB
+	minInstallDirSpace = 2 * 1024 * 1024
+	minVarSpace        = 1024 * 1024
+)
+
+var pciAddrRegex = regexp.MustCompile(`^[0-9a-fA-F]{4}:[0-9a-fA-F]{2}:[0-9a-fA-F]{2}\.[0-7]$`)
+
+type preflightCheck struct {
+	name string
+	run  func(b *blx) error
+}
+
+// read-only checks run on the host before anything is changed
+var preflightChecks = []preflightCheck{
+	{"distribution", requirePkgManager},
+	{"tools", checkHostTools},
+	{"disk_install_dir", checkInstallDirSpace},
+	{"disk_var", checkVarSpace},
+	{"cpus", checkHostCPUs},
+	{"memory", checkHostMemory},
+	{"interfaces", checkHostInterfaces},
+	{"ports", checkHostPorts},
+}
+
+func runPreflight(b *blx) (map[string]string, error) {
+	results := make(map[string]string)
+	var failed []string
+
+	for _, check := range preflightChecks {
+		err := check.run(b)
+		if err != nil {
+			results[check.name] = err.Error()
+			failed = append(failed, fmt.Sprintf(" - %s: %v", check.name, err))
+			log.Printf("[ERROR]  citrixblx-provider: Pre-flight check %s failed, %v", check.name, err)
+			continue
+		}
+		results[check.name] = preflightOK
+		log.Printf("[DEBUG]  citrixblx-provider: Pre-flight check %s SUCCESS", check.name)
+	}
+
+	if len(failed) != 0 {
+		sort.Strings(failed)
+		return results, fmt.Errorf("Pre-flight checks failed on host %s\n%s", b.host["ipaddress"], strings.Join(failed, "\n"))
+	}
+	return results, nil
+}
+
+func checkHostTools(b *blx) error {
+	var missing []string
+	for _, tool := range []string{"curl", "tar"} {
+		_, err := execCmdHost(b, fmt.Sprintf("command -v %s", tool))
+		if err != nil {
+			missing = append(missing, tool)
+		}
+	}
+	if len(missing) != 0 {
+		return fmt.Errorf("required tools not installed - %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// free space in KB for the filesystem holding path
+func hostFreeSpace(b *blx, path string) (int, error) {
+	out, err := execCmdHost(b, fmt.Sprintf("df -Pk %s | tail -1 | awk '{print $4}'", path))
+	if err != nil {
+		return 0, err
+	}
+	free, err := strconv.Atoi(strings.TrimSpace(out))
+	if err != nil {
+		return 0, fmt.Errorf("unable to parse free space of %s - %s", path, out)
+	}
+	return free, nil
+}
+
+func checkFreeSpace(b *blx, path string, min int) error {
+	free, err := hostFreeSpace(b, path)
+	if err != nil {
+		return err
+	}
+	if free < min {
+		return fmt.Errorf("%s has %d MB free, %d MB needed", path, free/1024, min/1024)
+	}
+	return nil
+}
+
+func checkInstallDirSpace(b *blx) error {
+	return checkFreeSpace(b, b.filePath["terraformInstallDir"], minInstallDirSpace)
+}
+
+func checkVarSpace(b *blx) error {
+	return checkFreeSpace(b, "/var", minVarSpace)
+}
+
+// number of cores needed for worker_processes, either a
+// process count or a core mask such as "-c 0x3"
+func workerCoresNeeded(workers string) (int, error) {
+	workers = strings.TrimSpace(workers)
+	if workers == "" {
+		return 0, nil
+	}
+	if strings.HasPrefix(workers, "-c") {
+		// masks of hosts with more than 64 cores do not fit a uint64
+		mask, ok := new(big.Int).SetString(strings.TrimPrefix(strings.TrimSpace(strings.TrimPrefix(workers, "-c")), "0x"), 16)
+		if !ok || mask.Sign() < 0 {
+			return 0, fmt.Errorf("invalid worker_processes core mask %s", workers)
+		}
+		return mask.BitLen(), nil
+	}
+	num, err := strconv.Atoi(workers)
+	if err != nil {
+		return 0, fmt.Errorf("invalid worker_processes %s", workers)
+	}
+	return num, nil
+}
+
+func checkHostCPUs(b *blx) error {
+	needed, err := workerCoresNeeded(b.config["worker_processes"])
+	if err != nil || needed == 0 {
+		return err
+	}
+	out, err := execCmdHost(b, "nproc")
+	if err != nil {
+		return err
+	}
+	cpus, err := strconv.Atoi(strings.TrimSpace(out))
+	if err != nil {
+		return fmt.Errorf("unable to parse cpu count - %s", out)
+	}
+	if needed > cpus {
+		return fmt.Errorf("worker_processes %s needs %d cpus, host has %d", b.config["worker_processes"], needed, cpus)
+	}
+	return nil
+}
+
+// parse a memory size such as "1024", "1024MB" or "2G" into MB
+func parseMemMB(mem string) (int, error) {
+	str := strings.ToUpper(strings.TrimSpace(mem))
+	multiplier := 1
+	if strings.HasSuffix(str, "G") || strings.HasSuffix(str, "GB") {
+		multiplier = 1024
+	}
+	str = strings.TrimRight(str, "GMB")
+	num, err := strconv.Atoi(strings.TrimSpace(str))
+	if err != nil {
+		return 0, fmt.Errorf("invalid memory size %s", mem)
+	}
+	return num * multiplier, nil
+}
+
+func parseMeminfo(out string) map[string]int {
+	meminfo := make(map[string]int)
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.Fields(strings.Replace(line, ":", " ", 1))
+		if len(fields) < 2 {
+			continue
+		}
+		val, err := strconv.Atoi(fields[1])
+		if err == nil {
+			meminfo[fields[0]] = val
+		}
+	}
+	return meminfo
+}
+
+func checkHostMemory(b *blx) error {
+	if b.config["total_hugepage_mem"] == "" {
+		return nil
+	}
+	needed, err := parseMemMB(b.config["total_hugepage_mem"])
+	if err != nil {
+		return err
+	}
+	out, err := execCmdHost(b, "cat /proc/meminfo")
+	if err != nil {
+		return err
+	}
+	meminfo := parseMeminfo(out)
+
+	// hugepages already reserved can be used by BLX
+	free := meminfo["MemAvailable"] + meminfo["HugePages_Free"]*meminfo["Hugepagesize"]
+	if needed > free/1024 {
+		return fmt.Errorf("total_hugepage_mem needs %d MB, host has %d MB available", needed, free/1024)
+	}
+	return nil
+}
+
+func checkHostInterfaces(b *blx) error {
+	var missing []string
+	for _, intf := range strings.Fields(b.config["interfaces"]) {
+		sysPath := fmt.Sprintf("/sys/class/net/%s", intf)
+		if pciAddrRegex.MatchString(intf) {
+			sysPath = fmt.Sprintf("/sys/bus/pci/devices/%s", intf)
+		}
+		_, err := execCmdHost(b, fmt.Sprintf("test -e %s", sysPath))
+		if err != nil {
+			missing = append(missing, intf)
+		}
+	}
+	if len(missing) != 0 {
+		return fmt.Errorf("interfaces not present on host - %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// shared mode management ports must be free, unless held by a running BLX
+func checkHostPorts(b *blx) error {
+	if b.config["ipaddress"] != "" {
+		return nil
+	}
+	num, err := blxProcessCount(b)
+	if err != nil || num != 0 {
+		return err
+	}
+
+	ports := map[string]string{
+		"mgmt_ssh_port":   "9022",
+		"mgmt_http_port":  "9080",
+		"mgmt_https_port": "9443",
+	}
+	var busy []string
+	for key, port := range ports {
+		if b.config[key] != "" {
+			port = b.config[key]
+		}
+		out, err := execCmdHost(b, fmt.Sprintf("ss -Hltn 'sport = :%s' | wc -l", port))
+		if err != nil {
+			return err
+		}
+		if strings.TrimSpace(out) != "0" {
+			busy = append(busy, port)
+		}
+	}
+	if len(busy) != 0 {
+		sort.Strings(busy)
+		return fmt.Errorf("management ports already in use - %s", strings.Join(busy, ", "))
+	}
+	return nil
+}
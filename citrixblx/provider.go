@@ -7,8 +7,40 @@ import (
 
 func Provider() terraform.ResourceProvider {
 	return &schema.Provider{
+		Schema: map[string]*schema.Schema{
+			"max_sessions_per_host": {
+				Type:     schema.TypeInt,
+				Optional: true,
+				Default:  defaultMaxSessions,
+			},
+			"keepalive_interval": {
+				Type:     schema.TypeInt,
+				Optional: true,
+				Default:  defaultKeepalive,
+			},
+			"force_unlock": {
+				Type:     schema.TypeString,
+				Optional: true,
+			},
+		},
 		ResourcesMap: map[string]*schema.Resource{
-			"citrixblx_adc": resourceCitrixBLXADC(),
+			"citrixblx_adc":         resourceCitrixBLXADC(),
+			"citrixblx_host_prep":   resourceCitrixBLXHostPrep(),
+			"citrixblx_host_reboot": resourceCitrixBLXHostReboot(),
+			"citrixblx_license":     resourceCitrixBLXLicense(),
+			"citrixblx_ha_pair":     resourceCitrixBLXHAPair(),
+			"citrixblx_cluster":     resourceCitrixBLXCluster(),
+		},
+		DataSourcesMap: map[string]*schema.Resource{
+			"citrixblx_host": dataSourceCitrixBLXHost(),
 		},
+		ConfigureFunc: providerConfigure,
 	}
 }
+
+// SSH connections are cached for the life of the provider process
+func providerConfigure(d *schema.ResourceData) (interface{}, error) {
+	forceUnlockID = d.Get("force_unlock").(string)
+	connCache.configure(d.Get("keepalive_interval").(int), d.Get("max_sessions_per_host").(int))
+	return connCache, nil
+}
@@ -0,0 +1,103 @@
+package citrixblx
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"time"
+)
+
+const (
+	rebootDropTimeout = 5 * time.Minute
+	rebootUpTimeout   = 15 * time.Minute
+)
+
+func hostBootID(b *blx) (string, error) {
+	out, err := execCmdHost(b, "cat /proc/sys/kernel/random/boot_id")
+	if err != nil {
+		return "", fmt.Errorf("Unable to get boot id of host %s.\r\n%v", b.host["ipaddress"], err)
+	}
+	return strings.TrimSpace(out), nil
+}
+
+func hostSSHAddr(host map[string]string) string {
+	port := host["port"]
+	if port == "" {
+		port = "22"
+	}
+	return net.JoinHostPort(host["ipaddress"], port)
+}
+
+// reboot the host, wait for SSH to drop and come back with a new
+// boot id, then re-initialize the host session
+func rebootHost(b *blx) error {
+	if isLocalHost(b.host) {
+		return fmt.Errorf("Host %s cannot be rebooted by terraform running on it with connection = \"%s\", reboot it and apply again", b.host["ipaddress"], connectionLocal)
+	}
+
+	bootID, err := hostBootID(b)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("[INFO]  citrixblx-provider: Rebooting host %s, boot id %s", b.host["ipaddress"], bootID)
+	_, err = execSudoCmdHost(b, "nohup bash -c 'sleep 2 ; reboot' > /dev/null 2>&1 &")
+	if err != nil {
+		return fmt.Errorf("Unable to reboot host %s.\r\n%v", b.host["ipaddress"], err)
+	}
+	b.hostSession.Close()
+	b.hostSession = nil
+
+	addr := hostSSHAddr(b.host)
+	for waited := time.Duration(0); waited < rebootDropTimeout; waited += pause(2 * time.Second) {
+		conn, err := dialTimeout("tcp", addr, 2*time.Second)
+		if err != nil {
+			log.Printf("[DEBUG]  citrixblx-provider: Host %s went down for reboot", addr)
+			break
+		}
+		conn.Close()
+	}
+
+	for waited := time.Duration(0); waited < rebootUpTimeout; waited += pause(10 * time.Second) {
+		b.hostSession, err = hostConnect(b.host)
+		if err != nil {
+			log.Printf("[WARN]  citrixblx-provider: Host %s not back after reboot, waiting", addr)
+			continue
+		}
+		newBootID, err := hostBootID(b)
+		if err == nil && newBootID != bootID {
+			log.Printf("[INFO]  citrixblx-provider: Host %s is back after reboot, boot id %s", addr, newBootID)
+			return initBLXHost(b)
+		}
+		b.hostSession.Close()
+		b.hostSession = nil
+	}
+
+	return fmt.Errorf("Host %s did not come back with a new boot id within %v after reboot", addr, rebootUpTimeout)
+}
+
+// reboot when an earlier step needs it and the resource allows it
+func rebootIfRequired(b *blx) error {
+	if !b.rebootRequired {
+		return nil
+	}
+	if !b.rebootIfRequired {
+		log.Printf("[WARN]  citrixblx-provider: Host %s needs a reboot, reboot_if_required is not set", b.host["ipaddress"])
+		return nil
+	}
+	err := rebootHost(b)
+	if err != nil {
+		return err
+	}
+	b.rebootRequired = false
+
+	// the mst devices of the Mellanox tools are gone after the reboot
+	if b.mlx["tools"] != "" || len(b.mlxFirmware) != 0 {
+		_, err = execSudoCmdHost(b, "mst start")
+		if err != nil {
+			return fmt.Errorf("Error starting mst on host %s after reboot.\r\n%v", b.host["ipaddress"], err)
+		}
+	}
+	return nil
+}
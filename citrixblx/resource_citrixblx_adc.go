@@ -5,6 +5,8 @@ import (
 	"github.com/hashicorp/terraform-plugin-sdk/helper/schema"
 	"log"
 	"net"
+	"os"
+	"time"
 )
 
 func resourceCitrixBLXADC() *schema.Resource {
@@ -14,44 +16,14 @@ func resourceCitrixBLXADC() *schema.Resource {
 		Update: resourceBLXUpdate,
 		Delete: resourceBLXDelete,
 
+		CustomizeDiff: resourceBLXCustomizeDiff,
+
 		Schema: map[string]*schema.Schema{
 			"source": {
 				Type:     schema.TypeString,
 				Required: true,
 			},
-			"host": {
-				Type:     schema.TypeMap,
-				Required: true,
-				Elem: &schema.Resource{
-					Schema: map[string]*schema.Schema{
-						"ipaddress": {
-							Type:     schema.TypeString,
-							Required: true,
-							ForceNew: true,
-						},
-						"username": {
-							Type:     schema.TypeString,
-							Required: true,
-						},
-						"password": {
-							Type:     schema.TypeString,
-							Optional: true,
-						},
-						"keyfile": {
-							Type:     schema.TypeString,
-							Optional: true,
-						},
-						"port": {
-							Type:     schema.TypeString,
-							Optional: true,
-						},
-						"ssh_hostkey_check": {
-							Type:     schema.TypeString,
-							Optional: true,
-						},
-					},
-				},
-			},
+			"host": hostSchema(),
 			"config": {
 				Type:     schema.TypeMap,
 				Optional: true,
@@ -97,6 +69,18 @@ func resourceCitrixBLXADC() *schema.Resource {
 							Type:     schema.TypeInt,
 							Optional: true,
 						},
+						"worker_cpus": {
+							Type:     schema.TypeString,
+							Optional: true,
+						},
+						"worker_cpus_skip_core0": {
+							Type:     schema.TypeString,
+							Optional: true,
+						},
+						"worker_cpus_skip_siblings": {
+							Type:     schema.TypeString,
+							Optional: true,
+						},
 						"cpu_yield": {
 							Type:     schema.TypeString,
 							Optional: true,
@@ -127,6 +111,41 @@ func resourceCitrixBLXADC() *schema.Resource {
 				Type:     schema.TypeString,
 				Optional: true,
 			},
+			"mlx_firmware": {
+				Type:     schema.TypeList,
+				Optional: true,
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"device": {
+							Type:     schema.TypeString,
+							Required: true,
+						},
+						"sriov_en": {
+							Type:     schema.TypeString,
+							Optional: true,
+						},
+						"num_of_vfs": {
+							Type:     schema.TypeInt,
+							Optional: true,
+						},
+						"link_type": {
+							Type:     schema.TypeString,
+							Optional: true,
+						},
+						"firmware_image": {
+							Type:     schema.TypeString,
+							Optional: true,
+						},
+					},
+				},
+			},
+			"mlx_firmware_version": {
+				Type:     schema.TypeMap,
+				Computed: true,
+				Elem: &schema.Schema{
+					Type: schema.TypeString,
+				},
+			},
 			"local_license": {
 				Type:     schema.TypeList,
 				Optional: true,
@@ -134,6 +153,209 @@ func resourceCitrixBLXADC() *schema.Resource {
 					Type: schema.TypeString,
 				},
 			},
+			"license_check": {
+				Type:     schema.TypeString,
+				Optional: true,
+				Default:  licenseCheckWarn,
+			},
+			"license_features": licenseFeaturesSchema(),
+			"pooled_license": {
+				Type:     schema.TypeList,
+				Optional: true,
+				MaxItems: 1,
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"server": {
+							Type:     schema.TypeString,
+							Required: true,
+						},
+						"port": {
+							Type:     schema.TypeInt,
+							Optional: true,
+							Default:  defaultLicenseServerPort,
+						},
+						"edition": {
+							Type:     schema.TypeString,
+							Optional: true,
+							Default:  "Platinum",
+						},
+						"bandwidth": {
+							Type:     schema.TypeInt,
+							Optional: true,
+						},
+						"instance_count": {
+							Type:     schema.TypeInt,
+							Optional: true,
+						},
+					},
+				},
+			},
+			"drain": {
+				Type:     schema.TypeList,
+				Optional: true,
+				MaxItems: 1,
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"vservers": {
+							Type:     schema.TypeList,
+							Optional: true,
+							Elem: &schema.Schema{
+								Type: schema.TypeString,
+							},
+						},
+						"threshold": {
+							Type:     schema.TypeInt,
+							Optional: true,
+						},
+						"timeout": {
+							Type:     schema.TypeInt,
+							Optional: true,
+							Default:  defaultDrainTimeout,
+						},
+					},
+				},
+			},
+			"upgrade_mode": {
+				Type:     schema.TypeString,
+				Optional: true,
+				Default:  upgradeModeInPlace,
+			},
+			"license_status": {
+				Type:     schema.TypeMap,
+				Computed: true,
+				Elem: &schema.Schema{
+					Type: schema.TypeString,
+				},
+			},
+			"sriov": {
+				Type:     schema.TypeList,
+				Optional: true,
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"pf": {
+							Type:     schema.TypeString,
+							Required: true,
+						},
+						"num_vfs": {
+							Type:     schema.TypeInt,
+							Required: true,
+						},
+						"vf": {
+							Type:     schema.TypeList,
+							Optional: true,
+							Elem: &schema.Resource{
+								Schema: map[string]*schema.Schema{
+									"index": {
+										Type:     schema.TypeInt,
+										Required: true,
+									},
+									"mac": {
+										Type:     schema.TypeString,
+										Optional: true,
+									},
+									"vlan": {
+										Type:     schema.TypeInt,
+										Optional: true,
+									},
+									"trust": {
+										Type:     schema.TypeString,
+										Optional: true,
+									},
+									"spoofchk": {
+										Type:     schema.TypeString,
+										Optional: true,
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+			"sriov_interfaces": {
+				Type:     schema.TypeList,
+				Computed: true,
+				Elem: &schema.Schema{
+					Type: schema.TypeString,
+				},
+			},
+			"reboot_if_required": {
+				Type:     schema.TypeBool,
+				Optional: true,
+			},
+			"dependency_bundle": {
+				Type:     schema.TypeString,
+				Optional: true,
+			},
+			"enable_epel": {
+				Type:     schema.TypeBool,
+				Optional: true,
+				Default:  true,
+			},
+			"package_lock_timeout": {
+				Type:     schema.TypeInt,
+				Optional: true,
+				Default:  defaultPkgLockTimeout,
+			},
+			"worker_cpu_mask": {
+				Type:     schema.TypeString,
+				Computed: true,
+			},
+			"preflight": {
+				Type:     schema.TypeMap,
+				Computed: true,
+				Elem: &schema.Schema{
+					Type: schema.TypeString,
+				},
+			},
+			"distro": {
+				Type:     schema.TypeString,
+				Computed: true,
+			},
+			"distro_version": {
+				Type:     schema.TypeString,
+				Computed: true,
+			},
+		},
+	}
+}
+
+// BLX host connection details, shared by resources and data sources
+func hostSchema() *schema.Schema {
+	return &schema.Schema{
+		Type:     schema.TypeMap,
+		Required: true,
+		Elem: &schema.Resource{
+			Schema: map[string]*schema.Schema{
+				"ipaddress": {
+					Type:     schema.TypeString,
+					Required: true,
+					ForceNew: true,
+				},
+				"username": {
+					Type:     schema.TypeString,
+					Required: true,
+				},
+				"password": {
+					Type:     schema.TypeString,
+					Optional: true,
+				},
+				"keyfile": {
+					Type:     schema.TypeString,
+					Optional: true,
+				},
+				"port": {
+					Type:     schema.TypeString,
+					Optional: true,
+				},
+				"ssh_hostkey_check": {
+					Type:     schema.TypeString,
+					Optional: true,
+				},
+				"connection": {
+					Type:     schema.TypeString,
+					Optional: true,
+				},
+			},
 		},
 	}
 }
@@ -183,16 +405,26 @@ func getBlxFromSchema(d *schema.ResourceData, function string) (blx, error) {
 	}
 
 	b := blx{
-		id:          id,
-		mlx:         mlx,
-		source:      source,
-		host:        host,
-		config:      config,
-		cliCmd:      cliCmdList,
-		password:    password,
-		nsSession:   nil,
-		hostSession: nil,
-		licenseList: licenseList,
+		id:               id,
+		mlx:              mlx,
+		source:           source,
+		host:             host,
+		config:           config,
+		cliCmd:           cliCmdList,
+		password:         password,
+		nsSession:        nil,
+		hostSession:      nil,
+		licenseList:      licenseList,
+		licenseCheck:     d.Get("license_check").(string),
+		pooledLicense:    getPooledLicenseInfo(d.Get("pooled_license").([]interface{})),
+		upgradeMode:      d.Get("upgrade_mode").(string),
+		drain:            getDrainInfo(d.Get("drain").([]interface{})),
+		depBundle:        d.Get("dependency_bundle").(string),
+		enableEPEL:       d.Get("enable_epel").(bool),
+		rebootIfRequired: d.Get("reboot_if_required").(bool),
+		sriov:            getSRIOVInfo(d.Get("sriov").([]interface{})),
+		mlxFirmware:      getMLXFirmwareInfo(d.Get("mlx_firmware").([]interface{})),
+		pkgLockTimeout:   time.Duration(d.Get("package_lock_timeout").(int)) * time.Second,
 	}
 	err := validateBLX(b)
 	if err != nil {
@@ -218,14 +450,60 @@ func getBlxFromSchema(d *schema.ResourceData, function string) (blx, error) {
 	}
 
 	if b.hostSession != nil {
-		initBLXHost(&b)
+		err = initBLXHost(&b)
+		if err != nil {
+			return b, err
+		}
 	}
 
-	if err != nil {
-		return b, err
+	return b, nil
+}
+
+// Update the computed attributes gathered from the host
+func setBLXComputed(d *schema.ResourceData, b *blx) {
+	if b.distro != "" {
+		d.Set("distro", b.distro)
+		d.Set("distro_version", b.distroVersion)
+	}
+	if b.workerCPUMask != "" {
+		d.Set("worker_cpu_mask", b.workerCPUMask)
+	}
+	if b.sriovInterfaces != nil {
+		d.Set("sriov_interfaces", b.sriovInterfaces)
 	}
+	if b.mlxFwVersion != nil {
+		d.Set("mlx_firmware_version", b.mlxFwVersion)
+	}
+	if b.preflight != nil {
+		d.Set("preflight", b.preflight)
+	}
+	if b.licenseFeatures != nil {
+		d.Set("license_features", flattenLicenseFeatures(b.licenseFeatures))
+	}
+	if b.licenseStatus != nil {
+		d.Set("license_status", b.licenseStatus)
+	}
+}
 
-	return b, nil
+// Inspect a local BLX source at plan time, distribution is checked on apply
+func resourceBLXCustomizeDiff(d *schema.ResourceDiff, m interface{}) error {
+	if !d.HasChange("source") {
+		return nil
+	}
+	source := d.Get("source").(string)
+	if source == "" || isURL(source) {
+		return nil
+	}
+	if _, err := os.Stat(source); err != nil {
+		return nil
+	}
+
+	tarball, err := inspectBLXTarball(source)
+	if err != nil {
+		return err
+	}
+	logBLXTarball(source, tarball)
+	return nil
 }
 
 func resourceBLXCreate(d *schema.ResourceData, m interface{}) error {
@@ -236,12 +514,19 @@ func resourceBLXCreate(d *schema.ResourceData, m interface{}) error {
 		return err
 	}
 
+	err = lockHost(&b)
+	if err != nil {
+		return err
+	}
+	defer unlockHost(&b)
+
 	err = setupBLX(&b)
 	if err != nil {
 		log.Printf("[ERROR] citrixblx-provider: Unable to Install BLX")
 		return err
 	}
 	d.SetId(b.id)
+	setBLXComputed(d, &b)
 
 	log.Printf("[DEBUG]  citrixblx-provider: BLX Create SUCCESS")
 	return nil
@@ -260,12 +545,59 @@ func resourceBLXUpdate(d *schema.ResourceData, m interface{}) error {
 		return err
 	}
 
+	err = lockHost(&b)
+	if err != nil {
+		return err
+	}
+	defer unlockHost(&b)
+
+	if d.HasChange("sriov") {
+		old, _ := d.GetChange("sriov")
+		b.sriovRemoved = removedSRIOVPFs(getSRIOVInfo(old.([]interface{})), b.sriov)
+	}
+
+	// a bad license or a source for another distribution fails the
+	// update before BLX is stopped
+	err = checkBLXUpdate(&b, d.HasChange("source"))
+	if err != nil {
+		return err
+	}
+
+	// rolling upgrade starts BLX itself, once the node is healthy.
+	// partial state keeps the old values in state when rolled back
+	d.Partial(true)
+	if d.HasChange("source") && b.upgradeMode == upgradeModeRolling {
+		err = rollingUpgradeBLX(&b, d.HasChange("mlx_firmware"))
+		if err != nil {
+			log.Printf("[ERROR] citrixblx-provider: Rolling upgrade of BLX failed in Update")
+			return err
+		}
+		d.Partial(false)
+		setBLXComputed(d, &b)
+		log.Printf("[INFO]  citrixblx-provider: BLX Update Succeeded")
+		return nil
+	}
+
+	// the host is reachable once BLX is stopped, back up before any change
+	err = stopAndBackupBLX(&b, d.HasChange("source"))
+	if err != nil {
+		log.Printf("[ERROR] citrixblx-provider: Unable to back up BLX in Update")
+		return err
+	}
+
+	if d.HasChange("mlx_firmware") {
+		err = configureMLXFirmware(&b)
+		if err != nil {
+			log.Printf("[ERROR] citrixblx-provider: Unable to configure Mellanox firmware in Update")
+			return rollbackBLX(&b, err)
+		}
+	}
+
 	if d.HasChange("source") {
 		err := installBLX(&b)
 		if err != nil {
-			d.SetId("")
 			log.Printf("[ERROR] citrixblx-provider: Unable to Install BLX in Update")
-			return err
+			return rollbackBLX(&b, err)
 		}
 	}
 
@@ -274,8 +606,10 @@ func resourceBLXUpdate(d *schema.ResourceData, m interface{}) error {
 	err = initBLX(&b)
 	if err != nil {
 		log.Printf("[ERROR] citrixblx-provider: Unable to update BLX with new parameters")
-		return err
+		return rollbackBLX(&b, err)
 	}
+	d.Partial(false)
+	setBLXComputed(d, &b)
 
 	log.Printf("[INFO]  citrixblx-provider: BLX Update Succeeded")
 	return nil
@@ -289,6 +623,12 @@ func resourceBLXDelete(d *schema.ResourceData, m interface{}) error {
 		return err
 	}
 
+	err = lockHost(&b)
+	if err != nil {
+		return err
+	}
+	defer unlockHost(&b)
+
 	err = destroyBLX(&b)
 	if err != nil {
 		log.Printf("[ERROR] citrixblx-provider: Unable to destroy BLX")
@@ -0,0 +1,206 @@
+package citrixblx
+
+import (
+	"fmt"
+	"github.com/hashicorp/terraform-plugin-sdk/helper/schema"
+	"log"
+)
+
+func resourceCitrixBLXCluster() *schema.Resource {
+	return &schema.Resource{
+		Create: resourceClusterCreate,
+		Read:   resourceClusterRead,
+		Update: resourceClusterUpdate,
+		Delete: resourceClusterDelete,
+
+		CustomizeDiff: resourceClusterCustomizeDiff,
+
+		Schema: map[string]*schema.Schema{
+			"cluster_id": {
+				Type:     schema.TypeInt,
+				Optional: true,
+				ForceNew: true,
+				Default:  1,
+			},
+			"cluster_ip": {
+				Type:     schema.TypeString,
+				Required: true,
+				ForceNew: true,
+			},
+			"backplane": {
+				Type:     schema.TypeString,
+				Optional: true,
+			},
+			"node": {
+				Type:     schema.TypeList,
+				Required: true,
+				MinItems: 1,
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"id": {
+							Type:     schema.TypeString,
+							Required: true,
+						},
+						"password": {
+							Type:     schema.TypeString,
+							Required: true,
+						},
+						"mgmt_ssh_port": {
+							Type:     schema.TypeString,
+							Optional: true,
+							Default:  "22",
+						},
+						"node_id": {
+							Type:     schema.TypeInt,
+							Required: true,
+						},
+						"state": {
+							Type:     schema.TypeString,
+							Optional: true,
+							Default:  "ACTIVE",
+						},
+						"backplane": {
+							Type:     schema.TypeString,
+							Optional: true,
+						},
+					},
+				},
+			},
+			"operational_state": {
+				Type:     schema.TypeString,
+				Computed: true,
+			},
+			"configuration_coordinator": {
+				Type:     schema.TypeString,
+				Computed: true,
+			},
+		},
+	}
+}
+
+func getClusterInfo(clusterID int, clusterIP string, backplane string, nodes []interface{}) (blxCluster, error) {
+	c := blxCluster{
+		clusterID: clusterID,
+		clusterIP: clusterIP,
+		backplane: backplane,
+		nodes:     getClusterNodeInfo(nodes),
+	}
+	return c, validateCluster(c)
+}
+
+func getClusterFromSchema(d *schema.ResourceData) (blxCluster, error) {
+	return getClusterInfo(d.Get("cluster_id").(int), d.Get("cluster_ip").(string), d.Get("backplane").(string), d.Get("node").([]interface{}))
+}
+
+// the first node holds the cluster instance, a changed first node
+// recreates the cluster
+func resourceClusterCustomizeDiff(d *schema.ResourceDiff, m interface{}) error {
+	if d.Id() != "" && d.HasChange("node.0.id") {
+		return d.ForceNew("node.0.id")
+	}
+	return nil
+}
+
+func resourceClusterCreate(d *schema.ResourceData, m interface{}) error {
+	log.Printf("[DEBUG]  citrixblx-provider: In Cluster Create Function")
+
+	c, err := getClusterFromSchema(d)
+	if err != nil {
+		return err
+	}
+
+	err = createCluster(c)
+	if err != nil {
+		log.Printf("[ERROR] citrixblx-provider: Unable to create cluster %s", c.clusterIP)
+		return err
+	}
+	d.SetId(fmt.Sprintf("%s-%d", c.clusterIP, c.clusterID))
+
+	coordinator, err := waitClusterOperational(c)
+	if err != nil {
+		return err
+	}
+	d.Set("operational_state", "UP")
+	d.Set("configuration_coordinator", coordinator)
+
+	log.Printf("[DEBUG]  citrixblx-provider: Cluster Create SUCCESS")
+	return nil
+}
+
+func resourceClusterRead(d *schema.ResourceData, m interface{}) error {
+	c, err := getClusterFromSchema(d)
+	if err != nil {
+		return err
+	}
+
+	clip, err := clusterConnect(c)
+	if err != nil {
+		return err
+	}
+	defer clip.Close()
+
+	status, members, err := clusterInstanceState(c, clip)
+	if err != nil {
+		return err
+	}
+	if len(members) == 0 {
+		log.Printf("[WARN]  citrixblx-provider: Cluster %s not found, removing from state", d.Id())
+		d.SetId("")
+		return nil
+	}
+	state := "DOWN"
+	if clusterOperational(c, status, members) {
+		state = "UP"
+	}
+	d.Set("operational_state", state)
+	d.Set("configuration_coordinator", clusterCoordinator(members))
+	return nil
+}
+
+func resourceClusterUpdate(d *schema.ResourceData, m interface{}) error {
+	log.Printf("[DEBUG]  citrixblx-provider: In Cluster Update Function")
+
+	c, err := getClusterFromSchema(d)
+	if err != nil {
+		return err
+	}
+	oldBackplane, _ := d.GetChange("backplane")
+	oldNodes, _ := d.GetChange("node")
+	old, err := getClusterInfo(c.clusterID, c.clusterIP, oldBackplane.(string), oldNodes.([]interface{}))
+	if err != nil {
+		return err
+	}
+
+	err = updateCluster(old, c)
+	if err != nil {
+		log.Printf("[ERROR] citrixblx-provider: Unable to update cluster %s", c.clusterIP)
+		return err
+	}
+	coordinator, err := waitClusterOperational(c)
+	if err != nil {
+		return err
+	}
+	d.Set("operational_state", "UP")
+	d.Set("configuration_coordinator", coordinator)
+
+	log.Printf("[INFO]  citrixblx-provider: Cluster Update Succeeded")
+	return nil
+}
+
+func resourceClusterDelete(d *schema.ResourceData, m interface{}) error {
+	log.Printf("[DEBUG]  citrixblx-provider: In Cluster Delete Function")
+
+	c, err := getClusterFromSchema(d)
+	if err != nil {
+		return err
+	}
+
+	err = destroyCluster(c)
+	if err != nil {
+		return err
+	}
+	d.SetId("")
+
+	log.Printf("[DEBUG]  citrixblx-provider: Cluster Destroy Succeeded")
+	return nil
+}
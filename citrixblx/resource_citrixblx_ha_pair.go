@@ -0,0 +1,174 @@
+package citrixblx
+
+import (
+	"fmt"
+	"github.com/hashicorp/terraform-plugin-sdk/helper/schema"
+	"log"
+)
+
+func resourceCitrixBLXHAPair() *schema.Resource {
+	return &schema.Resource{
+		Create: resourceHAPairCreate,
+		Read:   resourceHAPairRead,
+		Update: resourceHAPairUpdate,
+		Delete: resourceHAPairDelete,
+
+		Schema: map[string]*schema.Schema{
+			"node": {
+				Type:     schema.TypeList,
+				Required: true,
+				ForceNew: true,
+				MinItems: 2,
+				MaxItems: 2,
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"id": {
+							Type:     schema.TypeString,
+							Required: true,
+						},
+						"password": {
+							Type:     schema.TypeString,
+							Required: true,
+						},
+						"mgmt_ssh_port": {
+							Type:     schema.TypeString,
+							Optional: true,
+							Default:  "22",
+						},
+					},
+				},
+			},
+			"rpc_password": {
+				Type:     schema.TypeString,
+				Optional: true,
+			},
+			"ha_sync": {
+				Type:     schema.TypeBool,
+				Optional: true,
+				Default:  true,
+			},
+			"ha_propagation": {
+				Type:     schema.TypeBool,
+				Optional: true,
+				Default:  true,
+			},
+			"primary": {
+				Type:     schema.TypeString,
+				Computed: true,
+			},
+		},
+	}
+}
+
+func getHAPairFromSchema(d *schema.ResourceData) (haPair, error) {
+	pair := haPair{
+		nodes:       getHAPeerInfo(d.Get("node").([]interface{})),
+		rpcPassword: d.Get("rpc_password").(string),
+		sync:        d.Get("ha_sync").(bool),
+		propagation: d.Get("ha_propagation").(bool),
+	}
+	if len(pair.nodes) != 2 {
+		return pair, fmt.Errorf("Two nodes needed for HA pair")
+	}
+	if pair.nodes[0].id == pair.nodes[1].id {
+		return pair, fmt.Errorf("HA pair nodes must be different BLX, both are %s", pair.nodes[0].id)
+	}
+
+	err := haConnect(&pair)
+	if err != nil {
+		return pair, err
+	}
+	return pair, nil
+}
+
+func resourceHAPairCreate(d *schema.ResourceData, m interface{}) error {
+	log.Printf("[DEBUG]  citrixblx-provider: In HA Pair Create Function")
+
+	pair, err := getHAPairFromSchema(d)
+	if err != nil {
+		return err
+	}
+	defer haClose(&pair)
+
+	err = configureHAPair(&pair)
+	if err != nil {
+		return err
+	}
+	d.SetId(fmt.Sprintf("%s-%s", pair.nodes[0].id, pair.nodes[1].id))
+
+	primary, err := waitHAPair(&pair)
+	if err != nil {
+		return err
+	}
+	d.Set("primary", primary)
+
+	log.Printf("[DEBUG]  citrixblx-provider: HA Pair Create SUCCESS")
+	return nil
+}
+
+func resourceHAPairRead(d *schema.ResourceData, m interface{}) error {
+	pair, err := getHAPairFromSchema(d)
+	if err != nil {
+		return err
+	}
+	defer haClose(&pair)
+
+	paired, err := haPaired(&pair)
+	if err != nil {
+		return err
+	}
+	if !paired {
+		log.Printf("[WARN]  citrixblx-provider: HA pair %s not found, removing from state", d.Id())
+		d.SetId("")
+		return nil
+	}
+
+	primary, err := haPairPrimary(&pair)
+	if err != nil {
+		return err
+	}
+	d.Set("primary", primary)
+	return nil
+}
+
+func resourceHAPairUpdate(d *schema.ResourceData, m interface{}) error {
+	log.Printf("[DEBUG]  citrixblx-provider: In HA Pair Update Function")
+
+	pair, err := getHAPairFromSchema(d)
+	if err != nil {
+		return err
+	}
+	defer haClose(&pair)
+
+	err = configureHAPair(&pair)
+	if err != nil {
+		return err
+	}
+	primary, err := waitHAPair(&pair)
+	if err != nil {
+		return err
+	}
+	d.Set("primary", primary)
+
+	log.Printf("[INFO]  citrixblx-provider: HA Pair Update Succeeded")
+	return nil
+}
+
+func resourceHAPairDelete(d *schema.ResourceData, m interface{}) error {
+	log.Printf("[DEBUG]  citrixblx-provider: In HA Pair Delete Function")
+
+	pair, err := getHAPairFromSchema(d)
+	if err != nil {
+		return err
+	}
+	defer haClose(&pair)
+
+	err = removeHAPair(&pair)
+	if err != nil {
+		return err
+	}
+	d.SetId("")
+
+	log.Printf("[DEBUG]  citrixblx-provider: HA Pair Destroy Succeeded")
+	return nil
+}
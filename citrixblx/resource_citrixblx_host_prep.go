@@ -0,0 +1,162 @@
+package citrixblx
+
+import (
+	"fmt"
+	"github.com/hashicorp/terraform-plugin-sdk/helper/schema"
+	"log"
+)
+
+func resourceCitrixBLXHostPrep() *schema.Resource {
+	return &schema.Resource{
+		Create: resourceHostPrepCreate,
+		Read:   resourceHostPrepRead,
+		Update: resourceHostPrepUpdate,
+		Delete: resourceHostPrepDelete,
+
+		Schema: map[string]*schema.Schema{
+			"host": hostSchema(),
+			"hugepages": {
+				Type:     schema.TypeInt,
+				Optional: true,
+			},
+			"hugepage_size": {
+				Type:     schema.TypeString,
+				Optional: true,
+				Default:  hugepageSize2M,
+			},
+			"iommu": {
+				Type:     schema.TypeBool,
+				Optional: true,
+			},
+			"isolcpus": {
+				Type:     schema.TypeString,
+				Optional: true,
+			},
+			"reboot": {
+				Type:     schema.TypeBool,
+				Optional: true,
+			},
+			"kernel_cmdline": {
+				Type:     schema.TypeString,
+				Computed: true,
+			},
+			"hugepages_configured": {
+				Type:     schema.TypeInt,
+				Computed: true,
+			},
+			"reboot_required": {
+				Type:     schema.TypeBool,
+				Computed: true,
+			},
+		},
+	}
+}
+
+func getHostPrepFromSchema(d *schema.ResourceData) (blx, hostPrep, error) {
+	prep := hostPrep{
+		hugepages:    d.Get("hugepages").(int),
+		hugepageSize: d.Get("hugepage_size").(string),
+		iommu:        d.Get("iommu").(bool),
+		isolcpus:     d.Get("isolcpus").(string),
+		reboot:       d.Get("reboot").(bool),
+	}
+
+	b := blx{
+		host: getHostInfo(d.Get("host").(map[string]interface{})),
+	}
+	if b.host["ipaddress"] == "" {
+		return b, prep, fmt.Errorf("IP Address not provided for BLX Host")
+	}
+	if prep.hugepageSize != hugepageSize2M && prep.hugepageSize != hugepageSize1G {
+		return b, prep, fmt.Errorf("hugepage_size must be %s or %s", hugepageSize2M, hugepageSize1G)
+	}
+
+	var err error
+	b.hostSession, err = hostConnect(b.host)
+	if err != nil {
+		return b, prep, err
+	}
+	err = initBLXHost(&b)
+	if err != nil {
+		return b, prep, err
+	}
+	return b, prep, nil
+}
+
+func setHostPrepState(d *schema.ResourceData, state hostPrepState) {
+	d.Set("kernel_cmdline", state.cmdline)
+	d.Set("hugepages_configured", state.hugepages)
+	d.Set("reboot_required", state.rebootRequired)
+}
+
+func resourceHostPrepCreate(d *schema.ResourceData, m interface{}) error {
+	log.Printf("[DEBUG]  citrixblx-provider: In Host Prep Create Function")
+
+	b, prep, err := getHostPrepFromSchema(d)
+	if err != nil {
+		return err
+	}
+
+	state, err := prepHost(&b, prep)
+	if err != nil {
+		log.Printf("[ERROR] citrixblx-provider: Unable to prepare host %s", b.host["ipaddress"])
+		return err
+	}
+	d.SetId(b.host["ipaddress"])
+	setHostPrepState(d, state)
+
+	log.Printf("[DEBUG]  citrixblx-provider: Host Prep Create SUCCESS")
+	return nil
+}
+
+func resourceHostPrepRead(d *schema.ResourceData, m interface{}) error {
+	b, prep, err := getHostPrepFromSchema(d)
+	if err != nil {
+		return err
+	}
+
+	state, err := readHostPrep(&b, prep)
+	if err != nil {
+		return err
+	}
+	setHostPrepState(d, state)
+	return nil
+}
+
+func resourceHostPrepUpdate(d *schema.ResourceData, m interface{}) error {
+	log.Printf("[DEBUG]  citrixblx-provider: In Host Prep Update Function")
+
+	b, prep, err := getHostPrepFromSchema(d)
+	if err != nil {
+		return err
+	}
+
+	state, err := prepHost(&b, prep)
+	if err != nil {
+		log.Printf("[ERROR] citrixblx-provider: Unable to update host prep for %s", b.host["ipaddress"])
+		return err
+	}
+	setHostPrepState(d, state)
+
+	log.Printf("[INFO]  citrixblx-provider: Host Prep Update Succeeded")
+	return nil
+}
+
+func resourceHostPrepDelete(d *schema.ResourceData, m interface{}) error {
+	log.Printf("[DEBUG]  citrixblx-provider: In Host Prep Delete Function")
+
+	b, _, err := getHostPrepFromSchema(d)
+	if err != nil {
+		return err
+	}
+
+	err = unprepHost(&b)
+	if err != nil {
+		log.Printf("[ERROR] citrixblx-provider: Unable to remove host prep from %s", b.host["ipaddress"])
+		return err
+	}
+	d.SetId("")
+
+	log.Printf("[DEBUG]  citrixblx-provider: Host Prep Destroy Succeeded")
+	return nil
+}
@@ -0,0 +1,77 @@
+package citrixblx
+
+import (
+	"fmt"
+	"github.com/hashicorp/terraform-plugin-sdk/helper/schema"
+	"log"
+)
+
+func resourceCitrixBLXHostReboot() *schema.Resource {
+	return &schema.Resource{
+		Create: resourceHostRebootCreate,
+		Read:   resourceHostRebootRead,
+		Update: resourceHostRebootRead,
+		Delete: resourceHostRebootDelete,
+
+		Schema: map[string]*schema.Schema{
+			"host": hostSchema(),
+			"triggers": {
+				Type:     schema.TypeMap,
+				Optional: true,
+				ForceNew: true,
+				Elem: &schema.Schema{
+					Type: schema.TypeString,
+				},
+			},
+			"boot_id": {
+				Type:     schema.TypeString,
+				Computed: true,
+			},
+		},
+	}
+}
+
+func resourceHostRebootCreate(d *schema.ResourceData, m interface{}) error {
+	log.Printf("[DEBUG]  citrixblx-provider: In Host Reboot Create Function")
+
+	b := blx{
+		host: getHostInfo(d.Get("host").(map[string]interface{})),
+	}
+	if b.host["ipaddress"] == "" {
+		return fmt.Errorf("IP Address not provided for BLX Host")
+	}
+
+	var err error
+	b.hostSession, err = hostConnect(b.host)
+	if err != nil {
+		return err
+	}
+	err = initBLXHost(&b)
+	if err != nil {
+		return err
+	}
+
+	err = rebootHost(&b)
+	if err != nil {
+		log.Printf("[ERROR] citrixblx-provider: Unable to reboot host %s", b.host["ipaddress"])
+		return err
+	}
+	bootID, err := hostBootID(&b)
+	if err != nil {
+		return err
+	}
+	d.SetId(fmt.Sprintf("%s-%s", b.host["ipaddress"], bootID))
+	d.Set("boot_id", bootID)
+
+	log.Printf("[DEBUG]  citrixblx-provider: Host Reboot SUCCESS")
+	return nil
+}
+
+func resourceHostRebootRead(d *schema.ResourceData, m interface{}) error {
+	return nil
+}
+
+func resourceHostRebootDelete(d *schema.ResourceData, m interface{}) error {
+	d.SetId("")
+	return nil
+}
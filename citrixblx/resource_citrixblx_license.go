@@ -0,0 +1,296 @@
+package citrixblx
+
+import (
+	"fmt"
+	"github.com/hashicorp/terraform-plugin-sdk/helper/schema"
+	"log"
+	"net"
+	"path/filepath"
+	"strings"
+)
+
+func resourceCitrixBLXLicense() *schema.Resource {
+	return &schema.Resource{
+		Create:        resourceLicenseCreate,
+		Read:          resourceLicenseRead,
+		Update:        resourceLicenseUpdate,
+		Delete:        resourceLicenseDelete,
+		CustomizeDiff: resourceLicenseCustomizeDiff,
+
+		Schema: map[string]*schema.Schema{
+			"host": hostSchema(),
+			"source": {
+				Type:     schema.TypeString,
+				Required: true,
+			},
+			"blx_ipaddress": {
+				Type:     schema.TypeString,
+				Optional: true,
+			},
+			"mgmt_ssh_port": {
+				Type:     schema.TypeString,
+				Optional: true,
+			},
+			"license_check": {
+				Type:     schema.TypeString,
+				Optional: true,
+				Default:  licenseCheckWarn,
+			},
+			"checksum": {
+				Type:     schema.TypeString,
+				Computed: true,
+			},
+			"license_features": licenseFeaturesSchema(),
+		},
+	}
+}
+
+func licenseFeaturesSchema() *schema.Schema {
+	return &schema.Schema{
+		Type:     schema.TypeList,
+		Computed: true,
+		Elem: &schema.Resource{
+			Schema: map[string]*schema.Schema{
+				"file": {
+					Type:     schema.TypeString,
+					Computed: true,
+				},
+				"feature": {
+					Type:     schema.TypeString,
+					Computed: true,
+				},
+				"version": {
+					Type:     schema.TypeString,
+					Computed: true,
+				},
+				"expiry": {
+					Type:     schema.TypeString,
+					Computed: true,
+				},
+				"hostid": {
+					Type:     schema.TypeString,
+					Computed: true,
+				},
+			},
+		},
+	}
+}
+
+func flattenLicenseFeatures(features []licenseFeature) []interface{} {
+	list := make([]interface{}, 0, len(features))
+	for _, feature := range features {
+		list = append(list, map[string]interface{}{
+			"file":    feature.file,
+			"feature": feature.name,
+			"version": feature.version,
+			"expiry":  feature.expiry,
+			"hostid":  strings.Join(feature.hostIDs, " "),
+		})
+	}
+	return list
+}
+
+func getLicenseFromSchema(d *schema.ResourceData, function string) (blx, error) {
+	b := blx{
+		host:         getHostInfo(d.Get("host").(map[string]interface{})),
+		config:       make(map[string]string),
+		licenseCheck: d.Get("license_check").(string),
+	}
+	if b.host["ipaddress"] == "" {
+		return b, fmt.Errorf("IP Address not provided for BLX Host")
+	}
+	err := validateLicenseCheck(b.licenseCheck)
+	if err != nil {
+		return b, err
+	}
+
+	// BLX management address, used to wait for BLX after a restart
+	b.id = b.host["ipaddress"]
+	b.config["mgmt_ssh_port"] = d.Get("mgmt_ssh_port").(string)
+	if addr := d.Get("blx_ipaddress").(string); addr != "" {
+		ip, _, err := net.ParseCIDR(addr)
+		if err == nil {
+			addr = ip.String()
+		}
+		if net.ParseIP(addr) == nil {
+			return b, fmt.Errorf("Invalid blx_ipaddress %s, IP addr or CIDR notation expected", d.Get("blx_ipaddress").(string))
+		}
+		b.id = addr
+		b.config["ipaddress"] = addr
+	}
+
+	b.hostSession, err = hostConnect(b.host)
+	if err != nil {
+		return b, err
+	}
+	// read only checks the license files on the host
+	if function == "read" {
+		err = initHostDir(&b)
+	} else {
+		err = initBLXHost(&b)
+	}
+	if err != nil {
+		return b, err
+	}
+	return b, nil
+}
+
+func resourceLicenseCustomizeDiff(d *schema.ResourceDiff, m interface{}) error {
+	checksum, err := localFileChecksum(d.Get("source").(string))
+	if err != nil {
+		return err
+	}
+	if d.Get("checksum").(string) != checksum {
+		err = d.SetNew("checksum", checksum)
+		if err != nil {
+			return err
+		}
+		return d.SetNewComputed("license_features")
+	}
+	return nil
+}
+
+// check the license, upload it when the host copy differs, and restart
+// BLX for it. A previous license file named replaced is removed
+func applyLicense(b *blx, source string, replaced string) (string, error) {
+	features, err := validateLicenses(b, []string{source}, b.licenseCheck)
+	if err != nil {
+		return "", err
+	}
+	b.licenseFeatures = features
+
+	checksum, err := localFileChecksum(source)
+	if err != nil {
+		return "", err
+	}
+	name := filepath.Base(source)
+	changed := false
+	if replaced != "" && replaced != name {
+		err = removeLicense(b, replaced)
+		if err != nil {
+			return "", err
+		}
+		changed = true
+	}
+
+	hostChecksum, err := hostLicenseChecksum(b, name)
+	if err != nil {
+		return "", err
+	}
+	if hostChecksum != checksum {
+		err = uploadLicense(b, source)
+		if err != nil {
+			return "", err
+		}
+		changed = true
+	}
+	if !changed {
+		log.Printf("[DEBUG]  citrixblx-provider: License %s already on %s", name, b.host["ipaddress"])
+		return checksum, nil
+	}
+	return checksum, reloadLicense(b)
+}
+
+func resourceLicenseCreate(d *schema.ResourceData, m interface{}) error {
+	log.Printf("[DEBUG]  citrixblx-provider: In License Create Function")
+
+	b, err := getLicenseFromSchema(d, "create")
+	if err != nil {
+		return err
+	}
+
+	err = lockHost(&b)
+	if err != nil {
+		return err
+	}
+	defer unlockHost(&b)
+
+	source := d.Get("source").(string)
+	checksum, err := applyLicense(&b, source, "")
+	if err != nil {
+		log.Printf("[ERROR] citrixblx-provider: Unable to apply license %s on %s", source, b.host["ipaddress"])
+		return err
+	}
+	d.SetId(fmt.Sprintf("%s/%s", b.host["ipaddress"], filepath.Base(source)))
+	d.Set("checksum", checksum)
+	d.Set("license_features", flattenLicenseFeatures(b.licenseFeatures))
+
+	log.Printf("[DEBUG]  citrixblx-provider: License Create SUCCESS")
+	return nil
+}
+
+func resourceLicenseRead(d *schema.ResourceData, m interface{}) error {
+	b, err := getLicenseFromSchema(d, "read")
+	if err != nil {
+		return err
+	}
+
+	checksum, err := hostLicenseChecksum(&b, filepath.Base(d.Get("source").(string)))
+	if err != nil {
+		return err
+	}
+	if checksum == "" {
+		log.Printf("[WARN]  citrixblx-provider: License %s not found on host, removing from state", d.Id())
+		d.SetId("")
+		return nil
+	}
+	d.Set("checksum", checksum)
+	return nil
+}
+
+func resourceLicenseUpdate(d *schema.ResourceData, m interface{}) error {
+	log.Printf("[DEBUG]  citrixblx-provider: In License Update Function")
+
+	b, err := getLicenseFromSchema(d, "update")
+	if err != nil {
+		return err
+	}
+
+	err = lockHost(&b)
+	if err != nil {
+		return err
+	}
+	defer unlockHost(&b)
+
+	// a renamed license replaces the old file
+	oldSource, source := d.GetChange("source")
+	checksum, err := applyLicense(&b, source.(string), filepath.Base(oldSource.(string)))
+	if err != nil {
+		log.Printf("[ERROR] citrixblx-provider: Unable to update license %s on %s", source.(string), b.host["ipaddress"])
+		return err
+	}
+	d.SetId(fmt.Sprintf("%s/%s", b.host["ipaddress"], filepath.Base(source.(string))))
+	d.Set("checksum", checksum)
+	d.Set("license_features", flattenLicenseFeatures(b.licenseFeatures))
+
+	log.Printf("[INFO]  citrixblx-provider: License Update Succeeded")
+	return nil
+}
+
+func resourceLicenseDelete(d *schema.ResourceData, m interface{}) error {
+	log.Printf("[DEBUG]  citrixblx-provider: In License Delete Function")
+
+	b, err := getLicenseFromSchema(d, "delete")
+	if err != nil {
+		return err
+	}
+
+	err = lockHost(&b)
+	if err != nil {
+		return err
+	}
+	defer unlockHost(&b)
+
+	err = removeLicense(&b, filepath.Base(d.Get("source").(string)))
+	if err != nil {
+		return err
+	}
+	err = reloadLicense(&b)
+	if err != nil {
+		return err
+	}
+	d.SetId("")
+
+	log.Printf("[DEBUG]  citrixblx-provider: License Destroy Succeeded")
+	return nil
+}
@@ -3,15 +3,14 @@ package citrixblx
 import (
 	"bufio"
 	"fmt"
-	"github.com/tmc/scp"
 	"golang.org/x/crypto/ssh"
 	"golang.org/x/crypto/ssh/knownhosts"
 	"io/ioutil"
 	"log"
 	"net"
-	"net/url"
 	"os"
 	"path/filepath"
+	"regexp"
 	"strings"
 	"time"
 )
@@ -21,7 +20,27 @@ const (
 	pathEnvPreStr  = "export PATH=$PATH:/usr/local/sbin:/usr/sbin:/usr/local/bin:/usr/bin"
 )
 
-func hostConnect(hostInfo map[string]string) (*ssh.Client, error) {
+// cached SSH client of the host, shared by all resources on the host, or
+// the local machine for connection = "local"
+func connectHost(hostInfo map[string]string) (Transport, error) {
+	err := validateConnection(hostInfo)
+	if err != nil {
+		return nil, err
+	}
+	if isLocalHost(hostInfo) {
+		return localTransport{}, nil
+	}
+	key := connKey(hostInfo["username"], hostInfo["ipaddress"], hostInfo["port"], hostInfo["password"], hostInfo["keyfile"], hostInfo["ssh_hostkey_check"])
+	client, err := connCache.get(key, func() (*ssh.Client, error) {
+		return dialHost(hostInfo)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return sshTransport{client: client, cached: true}, nil
+}
+
+func dialHost(hostInfo map[string]string) (*ssh.Client, error) {
 	ipAddress := hostInfo["ipaddress"]
 	sshPort := hostInfo["port"]
 	if sshPort == "" {
@@ -75,7 +94,7 @@ func hostConnect(hostInfo map[string]string) (*ssh.Client, error) {
 	return client, err
 }
 
-func nsConnect(b *blx) (*ssh.Client, error) {
+func nsMgmtPort(b *blx) string {
 	var mgmtPort = "9022"
 	if b.config["mgmt_ssh_port"] != "" {
 		mgmtPort = b.config["mgmt_ssh_port"]
@@ -83,23 +102,47 @@ func nsConnect(b *blx) (*ssh.Client, error) {
 	if b.config["ipaddress"] != "" {
 		mgmtPort = "22"
 	}
+	return mgmtPort
+}
 
+// cached NS CLI client of the BLX, not to be closed by the caller
+func connectNS(b *blx) (Transport, error) {
+	key := connKey("nsroot", b.id, nsMgmtPort(b), b.password)
+	client, err := connCache.get(key, func() (*ssh.Client, error) {
+		return dialNS(b.id, nsMgmtPort(b), b.password)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return sshTransport{client: client, cached: true}, nil
+}
+
+// connect to the NS CLI of a BLX by management address
+func nsConnectAddr(address string, mgmtPort string, password string) (Transport, error) {
+	client, err := dialNS(address, mgmtPort, password)
+	if err != nil {
+		return nil, err
+	}
+	return sshTransport{client: client}, nil
+}
+
+func dialNS(address string, mgmtPort string, password string) (*ssh.Client, error) {
 	var errSession *ssh.Client
-	if !checkIP(b.id, mgmtPort) {
-		return errSession, fmt.Errorf("Unable to connect to NS - %s:%s", b.id, mgmtPort)
+	if !checkIP(address, mgmtPort) {
+		return errSession, fmt.Errorf("Unable to connect to NS - %s:%s", address, mgmtPort)
 	}
 
 	config := &ssh.ClientConfig{
 		User:            "nsroot",
 		Timeout:         time.Minute * time.Duration(10),
 		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
-		Auth:            []ssh.AuthMethod{ssh.Password(b.password)},
+		Auth:            []ssh.AuthMethod{ssh.Password(password)},
 	}
 
-	hostaddress := strings.Join([]string{b.id, mgmtPort}, ":")
+	hostaddress := strings.Join([]string{address, mgmtPort}, ":")
 	client, err := ssh.Dial("tcp", hostaddress, config)
 	if err != nil {
-		err = fmt.Errorf("Error connecting to NS - %s:%s, Error = %v", b.id, mgmtPort, err)
+		err = fmt.Errorf("Error connecting to NS - %s:%s, Error = %v", address, mgmtPort, err)
 	}
 
 	return client, err
@@ -120,6 +163,22 @@ func execSudoCmdHost(b *blx, cmd string) (string, error) {
 	return string(out), err
 }
 
+// open the host session and install dir when update or delete reached
+// BLX over the NS CLI and a step needs the host before BLX is stopped
+func ensureHostSession(b *blx) error {
+	if b.hostSession == nil {
+		var err error
+		b.hostSession, err = hostConnect(b.host)
+		if err != nil {
+			return fmt.Errorf("Unable to connect to host %s.\r\n%v", b.host["ipaddress"], err)
+		}
+	}
+	if b.filePath == nil {
+		return initBLXHost(b)
+	}
+	return nil
+}
+
 func execCmdHost(b *blx, cmd string) (string, error) {
 	return runCmd(b.hostSession, cmd)
 }
@@ -141,6 +200,8 @@ func checkIP(ipAddress string, port string) bool {
 	return false
 }
 
+var nsPasswdRegex = regexp.MustCompile(`(?i)(-password\s+)\S+`)
+
 func maskPasswd(cmd string) string {
 	if !strings.Contains(cmd, sudoPassPreStr) {
 		return cmd
@@ -154,53 +215,36 @@ func maskPasswd(cmd string) string {
 	return strings.Join(strSplit, ";")
 }
 
-func runNSShellCmd(client *ssh.Client, cmd string) (string, error) {
-	session, err := client.NewSession()
-	if err != nil {
-		return "", fmt.Errorf("Unable to create new session for running command - %s, Error = %v", cmd, err)
-	}
-	defer session.Close()
-
+func runNSShellCmd(client Transport, cmd string) (string, error) {
 	cmd = fmt.Sprintf("shell %s", cmd)
 	log.Printf("[DEBUG] citrixblx-provider: Executing command - %s", cmd)
-	out, err := session.CombinedOutput(cmd)
+	out, err := client.Run(cmd)
 	if err != nil {
 		log.Printf("[WARN] citrixblx-provider: Error returned while running command - %s", cmd)
 		log.Printf("[DEBUG] citrixblx-provider: Printing Error - \n %s", out)
 		err = fmt.Errorf("Error running command - %s, Error = %v\n%s", cmd, err, out)
 	}
-	return string(out), err
+	return out, err
 }
 
-func runNSCmd(client *ssh.Client, cmd string) (string, error) {
-	session, err := client.NewSession()
-	if err != nil {
-		return "", fmt.Errorf("Unable to create new session for running command - %s, Error = %v", cmd, err)
-	}
-	defer session.Close()
-
-	log.Printf("[DEBUG] citrixblx-provider: Executing command - %s", cmd)
-	out, err := session.CombinedOutput(cmd)
+func runNSCmd(client Transport, cmd string) (string, error) {
+	printCmd := nsPasswdRegex.ReplaceAllString(cmd, "${1}<PASSWD>")
+	log.Printf("[DEBUG] citrixblx-provider: Executing command - %s", printCmd)
+	out, err := client.Run(cmd)
 	if err != nil {
-		log.Printf("[WARN] citrixblx-provider: Error returned while running command - %s", cmd)
+		log.Printf("[WARN] citrixblx-provider: Error returned while running command - %s", printCmd)
 		log.Printf("[DEBUG] citrixblx-provider: Printing Error - \n %s", out)
-		err = fmt.Errorf("Error running command - %s, Error = %v\n%s", cmd, err, out)
+		err = fmt.Errorf("Error running command - %s, Error = %v\n%s", printCmd, err, out)
 	}
-	return string(out), err
+	return out, err
 }
 
-func runCmd(client *ssh.Client, cmd string) (string, error) {
-	session, err := client.NewSession()
-	if err != nil {
-		return "", fmt.Errorf("Unable to create new session for running command - %s, Error = %v", cmd, err)
-	}
-	defer session.Close()
-
+func runCmd(client Transport, cmd string) (string, error) {
 	printCmd := maskPasswd(cmd)
 	cmd = fmt.Sprintf("%s ; %s", pathEnvPreStr, cmd)
 	log.Printf("[DEBUG] citrixblx-provider: Executing command - %s", printCmd)
-	out, err := session.CombinedOutput(cmd)
-	log.Printf("[DEBUG] citrixblx-provider: Printing Output - \n%s", string(out))
+	out, err := client.Run(cmd)
+	log.Printf("[DEBUG] citrixblx-provider: Printing Output - \n%s", out)
 
 	if err != nil {
 		log.Printf("[WARN] citrixblx-provider: Error returned while running command - %s", printCmd)
@@ -208,33 +252,19 @@ func runCmd(client *ssh.Client, cmd string) (string, error) {
 		err = fmt.Errorf("Error running command - %s, Error = %v\n%s", printCmd, err, out)
 	}
 
-	return string(out), err
+	return out, err
 }
 
-func copyFile(client *ssh.Client, sourceFilePath string, destFilePath string) error {
-	session, err := client.NewSession()
-	if err != nil {
-		return err
-	}
-	defer session.Close()
-
+func copyFile(client Transport, sourceFilePath string, destFilePath string) error {
 	out, errCmd := runCmd(client, fmt.Sprintf("cd %s > /dev/null ; pwd", destFilePath))
 	if errCmd == nil {
 		destFilePath = strings.TrimSpace(out)
 	}
 
-	log.Printf("[DEBUG] citrixblx-provider: Copying file from %s to %s", sourceFilePath, destFilePath)
-	err = scp.CopyPath(sourceFilePath, destFilePath, session)
-	if err != nil {
-		log.Printf("[ERROR] citrixblx-provider: Failed to copy file from %s, to %s", sourceFilePath, destFilePath)
-		err = fmt.Errorf("Error copying file from %s to %s, Error = %v", sourceFilePath, destFilePath, err)
-	}
-	return err
+	return client.Copy(sourceFilePath, destFilePath)
 }
 
-func getFile(client *ssh.Client, source string, dest string) error {
-	isURL := func(str string) bool { u, err := url.Parse(str); return err == nil && u.Scheme != "" && u.Host != "" }
-
+func getFile(client Transport, source string, dest string) error {
 	_, err := runCmd(client, fmt.Sprintf("mkdir -p %s", dest))
 	if err != nil {
 		return fmt.Errorf("Error getting - %s, Error = %v", source, err)
@@ -265,7 +295,7 @@ func getFile(client *ssh.Client, source string, dest string) error {
 
 }
 
-func appendRemoteFile(client *ssh.Client, filePath string, str string) error {
+func appendRemoteFile(client Transport, filePath string, str string) error {
 	_, err := runCmd(client, fmt.Sprintf("echo \"%s\" >> %s", str, filePath))
 	return err
 }
@@ -278,7 +308,7 @@ func createFileHost(b *blx, remotePath string, lines []string) error {
 
 	tmpFilePath, err := filepath.Abs(file.Name())
 	if err != nil {
-		return fmt.Errorf("Error creating file %s. Error -\n%s", err)
+		return fmt.Errorf("Error creating file %s. Error -\n%s", remotePath, err)
 	}
 
 	err = os.Chmod(tmpFilePath, 0600)
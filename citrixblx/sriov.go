@@ -0,0 +1,229 @@
+package citrixblx
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+const (
+	sriovScript  = "/etc/blx/blx-sriov.sh"
+	sriovService = "blx-sriov.service"
+	sriovUnit    = "/etc/systemd/system/blx-sriov.service"
+)
+
+type sriovVF struct {
+	index    int
+	mac      string
+	vlan     int
+	trust    string
+	spoofchk string
+}
+
+type sriovPF struct {
+	pf     string
+	numVFs int
+	vfs    []sriovVF
+}
+
+func getSRIOVInfo(list []interface{}) []sriovPF {
+	pfs := make([]sriovPF, 0, len(list))
+	for _, i := range list {
+		m := i.(map[string]interface{})
+		pf := sriovPF{
+			pf:     m["pf"].(string),
+			numVFs: m["num_vfs"].(int),
+		}
+		for _, j := range m["vf"].([]interface{}) {
+			v := j.(map[string]interface{})
+			pf.vfs = append(pf.vfs, sriovVF{
+				index:    v["index"].(int),
+				mac:      v["mac"].(string),
+				vlan:     v["vlan"].(int),
+				trust:    v["trust"].(string),
+				spoofchk: v["spoofchk"].(string),
+			})
+		}
+		pfs = append(pfs, pf)
+	}
+	return pfs
+}
+
+// PFs of the previous config which are no longer configured
+func removedSRIOVPFs(old []sriovPF, cur []sriovPF) []string {
+	present := make(map[string]bool)
+	for _, pf := range cur {
+		present[pf.pf] = true
+	}
+	var removed []string
+	for _, pf := range old {
+		if !present[pf.pf] {
+			removed = append(removed, pf.pf)
+		}
+	}
+	return removed
+}
+
+func validateSRIOV(pfs []sriovPF) error {
+	for _, pf := range pfs {
+		if pf.numVFs <= 0 {
+			return fmt.Errorf("sriov num_vfs must be greater than 0 for %s", pf.pf)
+		}
+		for _, vf := range pf.vfs {
+			if vf.index < 0 || vf.index >= pf.numVFs {
+				return fmt.Errorf("sriov vf index %d out of range for %s with %d VFs", vf.index, pf.pf, pf.numVFs)
+			}
+			for _, val := range []string{vf.trust, vf.spoofchk} {
+				if val != "" && val != "on" && val != "off" {
+					return fmt.Errorf("sriov vf trust and spoofchk must be on or off, got %s", val)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// script run at every boot by blx-sriov.service, VFs are only
+// re-created when the count changes so running VFs are left alone
+func genSRIOVScript(pfs []sriovPF) []string {
+	lines := []string{"#!/bin/bash", "# generated by terraform"}
+	for _, pf := range pfs {
+		numvfs := fmt.Sprintf("/sys/class/net/%s/device/sriov_numvfs", pf.pf)
+		lines = append(lines,
+			fmt.Sprintf("if [ \"$(cat %s)\" != \"%d\" ] ; then", numvfs, pf.numVFs),
+			fmt.Sprintf("  echo 0 > %s", numvfs),
+			fmt.Sprintf("  echo %d > %s", pf.numVFs, numvfs),
+			"fi",
+		)
+		for _, vf := range pf.vfs {
+			cmd := fmt.Sprintf("ip link set %s vf %d", pf.pf, vf.index)
+			if vf.mac != "" {
+				cmd = fmt.Sprintf("%s mac %s", cmd, vf.mac)
+			}
+			if vf.vlan != 0 {
+				cmd = fmt.Sprintf("%s vlan %d", cmd, vf.vlan)
+			}
+			if vf.trust != "" {
+				cmd = fmt.Sprintf("%s trust %s", cmd, vf.trust)
+			}
+			if vf.spoofchk != "" {
+				cmd = fmt.Sprintf("%s spoofchk %s", cmd, vf.spoofchk)
+			}
+			lines = append(lines, cmd)
+		}
+	}
+	return lines
+}
+
+func genSRIOVUnit() []string {
+	return []string{
+		"[Unit]",
+		"Description=SR-IOV virtual functions for Citrix BLX",
+		"Before=network-pre.target blx.service",
+		"Wants=network-pre.target",
+		"",
+		"[Service]",
+		"Type=oneshot",
+		"RemainAfterExit=yes",
+		fmt.Sprintf("ExecStart=/bin/bash %s", sriovScript),
+		"",
+		"[Install]",
+		"WantedBy=multi-user.target",
+	}
+}
+
+// VF interface name, or its PCI address when the VF has no netdev
+func sriovVFInterface(b *blx, pf string, index int) (string, error) {
+	vfPath := fmt.Sprintf("/sys/class/net/%s/device/virtfn%d", pf, index)
+	for i := 0; i < 10; i++ {
+		out, err := execCmdHost(b, fmt.Sprintf("ls %s/net 2>/dev/null | head -1", vfPath))
+		if err == nil && strings.TrimSpace(out) != "" {
+			return strings.TrimSpace(out), nil
+		}
+		sleep(time.Second)
+	}
+	out, err := execCmdHost(b, fmt.Sprintf("basename $(readlink -f %s)", vfPath))
+	if err != nil || strings.TrimSpace(out) == "" {
+		return "", fmt.Errorf("Unable to resolve VF %d of %s.\r\n%v", index, pf, err)
+	}
+	return strings.TrimSpace(out), nil
+}
+
+// create the VFs persistently and add them to the BLX interfaces
+func setupSRIOV(b *blx) error {
+	for _, pf := range b.sriovRemoved {
+		_, err := execSudoCmdHost(b, fmt.Sprintf("echo 0 > /sys/class/net/%s/device/sriov_numvfs", pf))
+		if err != nil {
+			return fmt.Errorf("Error removing SR-IOV virtual functions of %s.\r\n%v", pf, err)
+		}
+	}
+	if len(b.sriov) == 0 {
+		if len(b.sriovRemoved) != 0 {
+			removeSRIOVService(b)
+		}
+		return nil
+	}
+
+	err := createFileHost(b, sriovScript, genSRIOVScript(b.sriov))
+	if err != nil {
+		return err
+	}
+	err = createFileHost(b, sriovUnit, genSRIOVUnit())
+	if err != nil {
+		return err
+	}
+	execSudoCmdHost(b, "systemctl daemon-reload")
+	_, err = execSudoCmdHost(b, fmt.Sprintf("systemctl enable %s", sriovService))
+	if err != nil {
+		return fmt.Errorf("Error enabling %s.\r\n%v", sriovService, err)
+	}
+	_, err = execSudoCmdHost(b, fmt.Sprintf("bash %s", sriovScript))
+	if err != nil {
+		return fmt.Errorf("Error creating SR-IOV virtual functions.\r\n%v", err)
+	}
+
+	interfaces := strings.Fields(b.config["interfaces"])
+	present := make(map[string]bool)
+	for _, intf := range interfaces {
+		present[intf] = true
+	}
+	b.sriovInterfaces = nil
+	for _, pf := range b.sriov {
+		for i := 0; i < pf.numVFs; i++ {
+			name, err := sriovVFInterface(b, pf.pf, i)
+			if err != nil {
+				return err
+			}
+			b.sriovInterfaces = append(b.sriovInterfaces, name)
+			if !present[name] {
+				interfaces = append(interfaces, name)
+				present[name] = true
+			}
+		}
+	}
+	b.config["interfaces"] = strings.Join(interfaces, " ")
+	log.Printf("[INFO]  citrixblx-provider: SR-IOV interfaces for BLX %s - %s", b.id, strings.Join(b.sriovInterfaces, " "))
+	return nil
+}
+
+// remove the VFs and the boot time service
+func removeSRIOV(b *blx) error {
+	if len(b.sriov) == 0 {
+		return nil
+	}
+	for _, pf := range b.sriov {
+		_, err := execSudoCmdHost(b, fmt.Sprintf("echo 0 > /sys/class/net/%s/device/sriov_numvfs", pf.pf))
+		if err != nil {
+			return fmt.Errorf("Error removing SR-IOV virtual functions of %s.\r\n%v", pf.pf, err)
+		}
+	}
+	removeSRIOVService(b)
+	return nil
+}
+
+func removeSRIOVService(b *blx) {
+	execSudoCmdHost(b, fmt.Sprintf("systemctl disable %s", sriovService))
+	execSudoCmdHost(b, fmt.Sprintf("rm -f %s %s", sriovScript, sriovUnit))
+	execSudoCmdHost(b, "systemctl daemon-reload")
+}
@@ -0,0 +1,170 @@
+package citrixblx
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strings"
+)
+
+type blxPackage struct {
+	name    string
+	version string
+	dist    string
+}
+
+type blxTarball struct {
+	topDir   string
+	dist     string
+	packages []blxPackage
+}
+
+func isURL(str string) bool {
+	u, err := url.Parse(str)
+	return err == nil && u.Scheme != "" && u.Host != ""
+}
+
+// parse name and version out of BLX package file names
+// rpm - <name>-<version>-<release>.<arch>.rpm
+// deb - <name>_<version>_<arch>.deb
+func parsePackageName(fileName string) (blxPackage, error) {
+	var pkg blxPackage
+	base := path.Base(fileName)
+
+	if strings.HasSuffix(base, ".rpm") {
+		pkg.dist = distRPM
+		nvr := strings.TrimSuffix(base, ".rpm")
+		if i := strings.LastIndex(nvr, "."); i > 0 {
+			nvr = nvr[:i]
+		}
+		parts := strings.Split(nvr, "-")
+		if len(parts) < 3 {
+			return pkg, fmt.Errorf("Unable to parse version from rpm package %s", base)
+		}
+		pkg.name = strings.Join(parts[:len(parts)-2], "-")
+		pkg.version = strings.Join(parts[len(parts)-2:], "-")
+		return pkg, nil
+	}
+
+	if strings.HasSuffix(base, ".deb") {
+		pkg.dist = distDEB
+		parts := strings.Split(strings.TrimSuffix(base, ".deb"), "_")
+		if len(parts) < 2 {
+			return pkg, fmt.Errorf("Unable to parse version from deb package %s", base)
+		}
+		pkg.name = parts[0]
+		pkg.version = parts[1]
+		return pkg, nil
+	}
+
+	return pkg, fmt.Errorf("%s is not a rpm or deb package", base)
+}
+
+// open the BLX tar.gz locally and check it has the layout installBLX expects
+// a single top level directory holding only rpm or only deb packages
+func inspectBLXTarball(source string) (blxTarball, error) {
+	var tarball blxTarball
+
+	file, err := os.Open(source)
+	if err != nil {
+		return tarball, fmt.Errorf("Unable to open BLX source %s, Error = %v", source, err)
+	}
+	defer file.Close()
+
+	gz, err := gzip.NewReader(file)
+	if err != nil {
+		return tarball, fmt.Errorf("BLX source %s is not a valid tar.gz archive, Error = %v", source, err)
+	}
+	defer gz.Close()
+
+	topDirs := make(map[string]bool)
+	reader := tar.NewReader(gz)
+	for {
+		hdr, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return tarball, fmt.Errorf("BLX source %s is corrupt, Error = %v", source, err)
+		}
+
+		name := strings.TrimPrefix(path.Clean(hdr.Name), "./")
+		if name == "." || name == "" {
+			continue
+		}
+		parts := strings.Split(name, "/")
+		if len(parts) == 1 && hdr.Typeflag != tar.TypeDir {
+			if strings.HasSuffix(name, ".rpm") || strings.HasSuffix(name, ".deb") {
+				return tarball, fmt.Errorf("BLX source %s has package %s outside of a directory", source, name)
+			}
+			continue
+		}
+		topDirs[parts[0]] = true
+
+		if hdr.Typeflag != tar.TypeReg || len(parts) != 2 {
+			continue
+		}
+		if !strings.HasSuffix(name, ".rpm") && !strings.HasSuffix(name, ".deb") {
+			continue
+		}
+		pkg, err := parsePackageName(name)
+		if err != nil {
+			return tarball, err
+		}
+		if tarball.dist != "" && tarball.dist != pkg.dist {
+			return tarball, fmt.Errorf("BLX source %s contains both rpm and deb packages", source)
+		}
+		tarball.dist = pkg.dist
+		tarball.packages = append(tarball.packages, pkg)
+	}
+
+	if len(topDirs) != 1 {
+		dirs := make([]string, 0, len(topDirs))
+		for dir := range topDirs {
+			dirs = append(dirs, dir)
+		}
+		sort.Strings(dirs)
+		return tarball, fmt.Errorf("BLX source %s must contain exactly one top level directory, found [%s]", source, strings.Join(dirs, ", "))
+	}
+	for dir := range topDirs {
+		tarball.topDir = dir
+	}
+
+	if len(tarball.packages) == 0 {
+		return tarball, fmt.Errorf("BLX source %s does not contain any rpm or deb packages in %s", source, tarball.topDir)
+	}
+
+	return tarball, nil
+}
+
+func logBLXTarball(source string, tarball blxTarball) {
+	log.Printf("[INFO]  citrixblx-provider: BLX source %s contains %s packages in %s", source, tarball.dist, tarball.topDir)
+	for _, pkg := range tarball.packages {
+		log.Printf("[INFO]  citrixblx-provider:    %s %s", pkg.name, pkg.version)
+	}
+}
+
+// validate a local BLX source against the host distribution,
+// URL sources are downloaded on the host and cannot be checked here
+func checkBLXSource(b *blx) error {
+	if isURL(b.source) {
+		return nil
+	}
+
+	tarball, err := inspectBLXTarball(b.source)
+	if err != nil {
+		return err
+	}
+	logBLXTarball(b.source, tarball)
+
+	if b.dist != "" && tarball.dist != b.dist {
+		return fmt.Errorf("BLX source %s contains %s packages but host %s needs %s packages", b.source, tarball.dist, b.host["ipaddress"], b.dist)
+	}
+	return nil
+}
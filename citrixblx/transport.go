@@ -0,0 +1,84 @@
+package citrixblx
+
+import (
+	"fmt"
+	"github.com/tmc/scp"
+	"golang.org/x/crypto/ssh"
+	"log"
+	"net"
+	"time"
+)
+
+// Transport runs commands and copies files on a host or a BLX. blx talks to
+// the host and the NS CLI only through it, over SSH, locally with
+// connection = "local", or through a scripted fake in tests
+type Transport interface {
+	// run cmd, returning its combined output
+	Run(cmd string) (string, error)
+	// copy a local file into dest, a directory or a file path
+	Copy(source string, dest string) error
+	Close() error
+}
+
+// connection and timing hooks, replaced in tests so that the install logic
+// runs without a lab
+var (
+	hostConnect = connectHost
+	nsConnect   = connectNS
+	dialTimeout = net.DialTimeout
+	sleep       = time.Sleep
+)
+
+// sleep for d and return it, polling loops add up the returned waits
+// instead of reading the clock so that tests faking sleep finish
+func pause(d time.Duration) time.Duration {
+	sleep(d)
+	return d
+}
+
+// commands as sessions on an SSH client, a cached client is shared with
+// other resources and stays open until the connection cache drops it
+type sshTransport struct {
+	client *ssh.Client
+	cached bool
+}
+
+func (t sshTransport) Run(cmd string) (string, error) {
+	release := connCache.acquire(t.client)
+	defer release()
+
+	session, err := t.client.NewSession()
+	if err != nil {
+		return "", err
+	}
+	defer session.Close()
+
+	out, err := session.CombinedOutput(cmd)
+	return string(out), err
+}
+
+func (t sshTransport) Copy(source string, dest string) error {
+	release := connCache.acquire(t.client)
+	defer release()
+
+	session, err := t.client.NewSession()
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+
+	log.Printf("[DEBUG] citrixblx-provider: Copying file from %s to %s", source, dest)
+	err = scp.CopyPath(source, dest, session)
+	if err != nil {
+		log.Printf("[ERROR] citrixblx-provider: Failed to copy file from %s, to %s", source, dest)
+		err = fmt.Errorf("Error copying file from %s to %s, Error = %v", source, dest, err)
+	}
+	return err
+}
+
+func (t sshTransport) Close() error {
+	if t.cached {
+		return nil
+	}
+	return t.client.Close()
+}
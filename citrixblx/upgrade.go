@@ -0,0 +1,209 @@
+package citrixblx
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+const (
+	upgradeModeInPlace = "in_place"
+	upgradeModeRolling = "ha_rolling"
+
+	upgradePeerTimeout   = 60 * time.Minute
+	upgradeHealthTimeout = 10 * time.Minute
+	failoverTimeout      = 5 * time.Minute
+)
+
+func validateUpgradeMode(mode string) error {
+	if mode != upgradeModeInPlace && mode != upgradeModeRolling {
+		return fmt.Errorf("upgrade_mode must be %s or %s", upgradeModeInPlace, upgradeModeRolling)
+	}
+	return nil
+}
+
+func nsShow(b *blx, cmd string) (string, error) {
+	client, err := nsConnect(b)
+	if err != nil {
+		return "", err
+	}
+	return runNSCmd(client, cmd)
+}
+
+func blxHANodes(b *blx) ([]haNodeState, error) {
+	out, err := nsShow(b, "show ha node")
+	if err != nil {
+		return nil, err
+	}
+	return parseHANodes(out), nil
+}
+
+// BLX version of a local source, empty when the source is a URL
+func sourceVersion(source string) string {
+	if isURL(source) {
+		return ""
+	}
+	tarball, err := inspectBLXTarball(source)
+	if err != nil || len(tarball.packages) == 0 {
+		return ""
+	}
+	for _, pkg := range tarball.packages {
+		if pkg.name == "blx" {
+			return pkg.version
+		}
+	}
+	return tarball.packages[0].version
+}
+
+// show ns version reports "NS13.1: Build 37.38", packages are versioned "13.1-37.38"
+func nsVersionMatch(out string, version string) bool {
+	parts := strings.SplitN(version, "-", 2)
+	if len(parts) != 2 {
+		return false
+	}
+	return strings.Contains(out, "NS"+parts[0]) && strings.Contains(out, "Build "+parts[1])
+}
+
+// healthy when reachable and, in an HA pair, both nodes UP with this node synced
+func blxHealthy(b *blx, paired bool) bool {
+	nodes, err := blxHANodes(b)
+	if err != nil {
+		log.Printf("[DEBUG]  citrixblx-provider: BLX %s not healthy yet, %v", b.id, err)
+		return false
+	}
+	if !paired {
+		return true
+	}
+	if len(nodes) < 2 {
+		return false
+	}
+	for _, node := range nodes {
+		if !strings.EqualFold(node.nodeState, "UP") {
+			return false
+		}
+	}
+	local := nodes[0]
+	return !strings.EqualFold(local.masterState, "secondary") || strings.EqualFold(local.syncState, "SUCCESS")
+}
+
+func waitBLXHealthy(b *blx, paired bool) error {
+	for start := time.Now(); time.Since(start) < upgradeHealthTimeout; sleep(10 * time.Second) {
+		if blxHealthy(b, paired) {
+			log.Printf("[INFO]  citrixblx-provider: BLX %s healthy after upgrade SUCCESS", b.id)
+			return nil
+		}
+	}
+	return fmt.Errorf("BLX %s not healthy within %v after upgrade", b.id, upgradeHealthTimeout)
+}
+
+// wait until the peer runs the new version and is synced as secondary. A
+// peer that went down for its upgrade and came back healthy on the old
+// version was rolled back, there is nothing left to wait for
+func waitPeerUpgraded(b *blx, peer *blx) error {
+	target := sourceVersion(b.source)
+	current, err := nsShow(b, "show ns version")
+	if err != nil {
+		return err
+	}
+
+	peerDown := false
+	for start := time.Now(); time.Since(start) < upgradePeerTimeout; sleep(30 * time.Second) {
+		version, err := nsShow(peer, "show ns version")
+		if err != nil {
+			log.Printf("[DEBUG]  citrixblx-provider: HA peer %s not reachable, waiting", peer.id)
+			peerDown = true
+			continue
+		}
+		upgraded := version != current
+		if target != "" {
+			upgraded = nsVersionMatch(version, target)
+		}
+		healthy := blxHealthy(peer, true)
+		if upgraded && healthy {
+			log.Printf("[INFO]  citrixblx-provider: HA peer %s upgraded and synced", peer.id)
+			return nil
+		}
+		if !upgraded && healthy && peerDown {
+			return fmt.Errorf("HA peer %s is back on its previous version, its upgrade was rolled back. %s is not upgraded", peer.id, b.id)
+		}
+		log.Printf("[DEBUG]  citrixblx-provider: Waiting for HA peer %s to be upgraded", peer.id)
+	}
+	return fmt.Errorf("HA peer %s not upgraded within %v, the peer must be upgraded first with upgrade_mode = \"%s\"", peer.id, upgradePeerTimeout, upgradeModeRolling)
+}
+
+func forceHAFailover(b *blx) error {
+	log.Printf("[INFO]  citrixblx-provider: Forcing HA failover from %s", b.id)
+	_, err := nsShow(b, "force ha failover -force")
+	if err != nil {
+		return fmt.Errorf("Error forcing HA failover on %s.\r\n%v", b.id, err)
+	}
+	for start := time.Now(); time.Since(start) < failoverTimeout; sleep(5 * time.Second) {
+		nodes, err := blxHANodes(b)
+		if err == nil && len(nodes) != 0 && strings.EqualFold(nodes[0].masterState, "secondary") {
+			log.Printf("[INFO]  citrixblx-provider: %s is secondary after failover", b.id)
+			return nil
+		}
+	}
+	return fmt.Errorf("%s did not become secondary within %v after HA failover", b.id, failoverTimeout)
+}
+
+// install and start the new source, going back to the backed up
+// package and config when the node does not come back healthy
+func upgradeNode(b *blx, paired bool, mlxFirmware bool) error {
+	err := stopAndBackupBLX(b, true)
+	if err != nil {
+		return err
+	}
+
+	if mlxFirmware {
+		err = configureMLXFirmware(b)
+		if err != nil {
+			return rollbackBLX(b, err)
+		}
+	}
+
+	err = installBLX(b)
+	if err == nil {
+		err = initBLX(b)
+	}
+	if err == nil {
+		err = waitBLXHealthy(b, paired)
+	}
+	if err != nil {
+		return rollbackBLX(b, err)
+	}
+	return nil
+}
+
+// upgrade one node of an HA pair without taking the pair down. The
+// secondary upgrades right away, the primary waits for its peer to be
+// upgraded, fails over to it and then upgrades. Changed Mellanox firmware
+// settings are applied while the node is down
+func rollingUpgradeBLX(b *blx, mlxFirmware bool) error {
+	nodes, err := blxHANodes(b)
+	if err != nil || len(nodes) < 2 {
+		log.Printf("[WARN]  citrixblx-provider: BLX %s is not in an HA pair, upgrading in place", b.id)
+		return upgradeNode(b, false, mlxFirmware)
+	}
+
+	local := nodes[0]
+	peer := blx{
+		id:       nodes[1].ip,
+		config:   b.config,
+		password: b.password,
+	}
+	log.Printf("[INFO]  citrixblx-provider: Rolling upgrade of BLX %s, %s in HA pair with %s", b.id, local.masterState, peer.id)
+
+	if strings.EqualFold(local.masterState, "primary") {
+		err = waitPeerUpgraded(b, &peer)
+		if err != nil {
+			return err
+		}
+		err = forceHAFailover(b)
+		if err != nil {
+			return err
+		}
+	}
+	return upgradeNode(b, true, mlxFirmware)
+}